@@ -0,0 +1,234 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+)
+
+// Get returns the value at key in ConfigMap. It is mostly useful when the result passed
+// to Load() is a map rather than a struct. Key elements are matched against map keys
+// case-insensitively, and can be struct field names or aliases.
+// Error is returned if the key doesn't exist.
+func (md *Metadata) Get(key ...string) (interface{}, error) {
+	if len(key) == 0 {
+		return nil, errors.New("key must not be empty")
+	}
+
+	ak := md.fullAliasedKey(key)
+	val := reflect.ValueOf(md.ConfigMap)
+	for i, keyElem := range ak {
+		if val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		if val.Kind() != reflect.Map || val.Type().Key().Kind() != reflect.String {
+			return nil, errors.Errorf("key '%s' not found; '%s' is not a map", Key(key), Key(key[:i]))
+		}
+
+		found := false
+		for _, mk := range val.MapKeys() {
+			if keyElem.Equal(reflection.AliasedKeyElem{mk.String()}) {
+				val = val.MapIndex(mk)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("key '%s' not found", Key(key))
+		}
+	}
+
+	return val.Interface(), nil
+}
+
+// GetString returns the string value at key. Error is returned if the key doesn't exist
+// or the value isn't a string.
+func (md *Metadata) GetString(key ...string) (string, error) {
+	return GetAs[string](md, key...)
+}
+
+// GetInt returns the integer value at key. Floats with no fractional part are accepted,
+// as are strings (like those from environment variables) that parse as integers.
+// Error is returned if the key doesn't exist or the value isn't an integer that fits in
+// an int.
+func (md *Metadata) GetInt(key ...string) (int, error) {
+	val, err := md.Get(key...)
+	if err != nil {
+		return 0, err
+	}
+
+	if s, ok := val.(string); ok {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.Wrapf(err, "value of key '%s' is not an int", Key(key))
+		}
+		return i, nil
+	}
+
+	var i int
+	if err := convertNumber(val, &i); err != nil {
+		return 0, errors.Wrapf(err, "value of key '%s' is not an int", Key(key))
+	}
+	return i, nil
+}
+
+// GetBool returns the boolean value at key. Strings (like those from environment
+// variables) are parsed with strconv.ParseBool. Error is returned if the key doesn't
+// exist or the value isn't a bool.
+func (md *Metadata) GetBool(key ...string) (bool, error) {
+	val, err := md.Get(key...)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, errors.Wrapf(err, "value of key '%s' is not a bool", Key(key))
+		}
+		return b, nil
+	}
+	return false, errors.Errorf("value of key '%s' is not a bool; got %T", Key(key), val)
+}
+
+// GetDuration returns the duration value at key. Strings are parsed with
+// time.ParseDuration (like "1m30s") and integers are treated as nanoseconds (which is
+// how time.Duration struct fields are encoded). Error is returned if the key doesn't
+// exist or the value isn't a duration.
+func (md *Metadata) GetDuration(key ...string) (time.Duration, error) {
+	val, err := md.Get(key...)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := val.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, errors.Wrapf(err, "value of key '%s' is not a duration", Key(key))
+		}
+		return d, nil
+	}
+
+	var ns int64
+	if err := convertNumber(val, &ns); err != nil {
+		return 0, errors.Wrapf(err, "value of key '%s' is not a duration", Key(key))
+	}
+	return time.Duration(ns), nil
+}
+
+// GetStringSlice returns the string slice value at key. Error is returned if the key
+// doesn't exist or the value isn't a slice of strings.
+func (md *Metadata) GetStringSlice(key ...string) ([]string, error) {
+	val, err := md.Get(key...)
+	if err != nil {
+		return nil, err
+	}
+
+	if ss, ok := val.([]string); ok {
+		return ss, nil
+	}
+
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice {
+		return nil, errors.Errorf("value of key '%s' is not a slice; got %T", Key(key), val)
+	}
+
+	ss := make([]string, rv.Len())
+	for i := range ss {
+		s, ok := rv.Index(i).Interface().(string)
+		if !ok {
+			return nil, errors.Errorf("element %d of key '%s' is not a string; got %T", i, Key(key), rv.Index(i).Interface())
+		}
+		ss[i] = s
+	}
+	return ss, nil
+}
+
+// GetAs returns the value at key in md.ConfigMap as type T. The value must be of type T,
+// except that numbers are converted between numeric types if that can be done without
+// loss (so GetAs[uint16] works for an int64 value of 8080).
+// Error is returned if the key doesn't exist or the value can't be converted to T.
+func GetAs[T any](md *Metadata, key ...string) (T, error) {
+	var result T
+
+	val, err := md.Get(key...)
+	if err != nil {
+		return result, err
+	}
+
+	if t, ok := val.(T); ok {
+		return t, nil
+	}
+
+	if err := convertNumber(val, &result); err != nil {
+		return result, errors.Wrapf(err, "value of key '%s' is not %T", Key(key), result)
+	}
+	return result, nil
+}
+
+// convertNumber sets *dst (which must be a pointer to a numeric type) to the numeric val.
+// Error is returned if either isn't numeric, or if the conversion would lose more than
+// float precision (overflow, a fractional part, or a negative value into an unsigned type).
+func convertNumber(val interface{}, dst interface{}) error {
+	dstVal := reflect.ValueOf(dst).Elem()
+	srcVal := reflect.ValueOf(val)
+	if !srcVal.IsValid() || !isNumericKind(srcVal.Kind()) || !isNumericKind(dstVal.Kind()) {
+		return errors.Errorf("can't convert %T to %s", val, dstVal.Type())
+	}
+
+	converted := srcVal.Convert(dstVal.Type())
+
+	var lossy bool
+	switch {
+	case isFloatKind(dstVal.Kind()):
+		// Precision loss is expected with floats, but overflow isn't
+		lossy = math.IsInf(converted.Float(), 0) && !math.IsInf(toFloat(srcVal), 0)
+	case isFloatKind(srcVal.Kind()):
+		f := srcVal.Float()
+		lossy = f != math.Trunc(f) || math.IsInf(f, 0) || math.IsNaN(f) ||
+			toFloat(converted) != f
+	default:
+		// Integer to integer. Converting back must give the same value, and the signs must agree.
+		lossy = !converted.Convert(srcVal.Type()).Equal(srcVal) || isNegative(srcVal) != isNegative(converted)
+	}
+	if lossy {
+		return errors.Errorf("%v does not fit in %s", val, dstVal.Type())
+	}
+
+	dstVal.Set(converted)
+	return nil
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	return isFloatKind(kind) || (kind >= reflect.Int && kind <= reflect.Uint64)
+}
+
+func isFloatKind(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+// toFloat returns the numeric v as a float64.
+func toFloat(v reflect.Value) float64 {
+	return v.Convert(reflect.TypeOf(float64(0))).Float()
+}
+
+// isNegative returns true if the integer v is negative.
+func isNegative(v reflect.Value) bool {
+	return v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64 && v.Int() < 0
+}
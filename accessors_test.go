@@ -0,0 +1,117 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func TestMetadata_Accessors(t *testing.T) {
+	doc := `
+listen_port = 8080
+ratio = 1.5
+whole = 2.0
+debug = true
+timeout = "1m30s"
+hosts = ["a", "b"]
+mixed = [1, 2]
+big = 9223372036854775807
+negative = -1
+
+[Server]
+Name = "srv"
+`
+	os.Clearenv()
+	os.Setenv("VERBOSE", "true")
+	os.Setenv("WORKERS", "4")
+	envOverrides := []EnvOverride{
+		{EnvVar: "VERBOSE", Key: Key{"verbose"}},
+		{EnvVar: "WORKERS", Key: Key{"workers"}},
+	}
+
+	var config map[string]interface{}
+	md, err := Load(toml.Codec, makeStringReaders([]string{doc}), nil, nil, envOverrides, &config)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		get     func() (interface{}, error)
+		want    interface{}
+		wantErr bool
+	}{
+		{"Get", func() (interface{}, error) { return md.Get("listen_port") }, int64(8080), false},
+		{"Get case-insensitive", func() (interface{}, error) { return md.Get("server", "name") }, "srv", false},
+		{"Get map", func() (interface{}, error) { return md.Get("Server") }, map[string]interface{}{"Name": "srv"}, false},
+		{"error: Get missing", func() (interface{}, error) { return md.Get("nope") }, nil, true},
+		{"error: Get into non-map", func() (interface{}, error) { return md.Get("listen_port", "x") }, nil, true},
+		{"error: Get empty key", func() (interface{}, error) { return md.Get() }, nil, true},
+		{"GetString", func() (interface{}, error) { return md.GetString("Server", "Name") }, "srv", false},
+		{"error: GetString not string", func() (interface{}, error) { return md.GetString("listen_port") }, "", true},
+		{"GetInt", func() (interface{}, error) { return md.GetInt("listen_port") }, 8080, false},
+		{"GetInt whole float", func() (interface{}, error) { return md.GetInt("whole") }, 2, false},
+		{"GetInt from env string", func() (interface{}, error) { return md.GetInt("workers") }, 4, false},
+		{"error: GetInt fraction", func() (interface{}, error) { return md.GetInt("ratio") }, 0, true},
+		{"error: GetInt not number", func() (interface{}, error) { return md.GetInt("hosts") }, 0, true},
+		{"GetBool", func() (interface{}, error) { return md.GetBool("debug") }, true, false},
+		{"GetBool from env string", func() (interface{}, error) { return md.GetBool("verbose") }, true, false},
+		{"error: GetBool not bool", func() (interface{}, error) { return md.GetBool("listen_port") }, false, true},
+		{"GetDuration", func() (interface{}, error) { return md.GetDuration("timeout") }, 90 * time.Second, false},
+		{"GetDuration from int", func() (interface{}, error) { return md.GetDuration("listen_port") }, time.Duration(8080), false},
+		{"error: GetDuration bad string", func() (interface{}, error) { return md.GetDuration("Server", "Name") }, time.Duration(0), true},
+		{"GetStringSlice", func() (interface{}, error) { return md.GetStringSlice("hosts") }, []string{"a", "b"}, false},
+		{"error: GetStringSlice mixed", func() (interface{}, error) { return md.GetStringSlice("mixed") }, []string(nil), true},
+		{"error: GetStringSlice not slice", func() (interface{}, error) { return md.GetStringSlice("debug") }, []string(nil), true},
+		{"GetAs exact", func() (interface{}, error) { return GetAs[float64](&md, "ratio") }, 1.5, false},
+		{"GetAs numeric conversion", func() (interface{}, error) { return GetAs[uint16](&md, "listen_port") }, uint16(8080), false},
+		{"GetAs float32", func() (interface{}, error) { return GetAs[float32](&md, "ratio") }, float32(1.5), false},
+		{"GetAs map", func() (interface{}, error) { return GetAs[map[string]interface{}](&md, "Server") }, map[string]interface{}{"Name": "srv"}, false},
+		{"error: GetAs overflow", func() (interface{}, error) { return GetAs[uint8](&md, "listen_port") }, uint8(0), true},
+		{"error: GetAs big into int32", func() (interface{}, error) { return GetAs[int32](&md, "big") }, int32(0), true},
+		{"error: GetAs negative into uint", func() (interface{}, error) { return GetAs[uint64](&md, "negative") }, uint64(0), true},
+		{"error: GetAs fraction into int", func() (interface{}, error) { return GetAs[int](&md, "ratio") }, 0, true},
+		{"error: GetAs wrong type", func() (interface{}, error) { return GetAs[bool](&md, "Server", "Name") }, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata_Get_Struct(t *testing.T) {
+	type config struct {
+		Server struct {
+			ListenPort uint16 `toml:"listen_port"`
+		} `toml:"server"`
+	}
+
+	os.Clearenv()
+	var result config
+	md, err := Load(toml.Codec, makeStringReaders([]string{"[server]\nlisten_port = 80"}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Struct field names can be used instead of aliases
+	port, err := GetAs[uint16](&md, "Server", "ListenPort")
+	if err != nil || port != 80 {
+		t.Fatalf("GetAs failed; got %v, %v", port, err)
+	}
+}
@@ -0,0 +1,379 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/untyped"
+	"github.com/pkg/errors"
+)
+
+// UntypedCodec is optionally implemented by a Codec for a config language whose values
+// are untyped strings (like INI). Before the values from such a codec are checked
+// against the result struct, Load() calls ConvertString for each string value that
+// corresponds to a struct field, so that the usual type checks apply.
+// The untyped package provides helpers for implementing such codecs.
+type UntypedCodec interface {
+	Codec
+
+	// ConvertString converts s into a value of a type suitable for field (such as
+	// int64 for an int field). field will never be nil. It may be a map field, in which
+	// case s is a value within that map.
+	ConvertString(s string, field *reflection.StructField) (interface{}, error)
+}
+
+// FlatCodec is optionally implemented by a Codec for a config language that has no
+// nested keys (like .env files). Load() maps each flat key onto the result struct by
+// matching it against the field names or aliases along the key path, joined by
+// KeySeparator(), case-insensitively. So "SERVER_LISTENPORT" matches Server.ListenPort.
+// (If the result is a map, the keys are left flat.)
+type FlatCodec interface {
+	Codec
+
+	// KeySeparator returns the string that joins the elements of flat keys, like "_".
+	KeySeparator() string
+}
+
+// PositionCodec is optionally implemented by a Codec that can report where in the config
+// data each key is found. Load() uses it to set the Line and Column of the provenance of
+// fields whose values come from the codec's readers.
+type PositionCodec interface {
+	Codec
+
+	// KeyPositions returns the positions of the keys in data. The keys are as they
+	// appear in data (so they are flat keys if the codec is also a FlatCodec). It
+	// is not necessary to report the positions of branches. If it returns an error,
+	// Load() logs it and leaves the Line and Column of the reader's provenances zero.
+	KeyPositions(data []byte) ([]reflection.KeyPosition, error)
+}
+
+var codecRegistry = struct {
+	sync.RWMutex
+	byExt map[string]Codec
+}{byExt: make(map[string]Codec)}
+
+// RegisterCodec associates a filename extension (like ".toml") with a codec. FindFiles
+// uses the registered codecs to pick the codec for each file it finds, which allows
+// config files of different formats to be mixed in a single Load().
+//
+// The json and toml packages do not register themselves (so that you don't pull in
+// dependencies you aren't using); do it explicitly, like:
+//  configloader.RegisterCodec(".toml", toml.Codec)
+//  configloader.RegisterCodec(".json", json.Codec)
+//
+// Extensions are matched case-insensitively. Registering an extension again replaces the
+// previous codec. Registering a nil codec removes the extension.
+func RegisterCodec(ext string, codec Codec) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	codecRegistry.Lock()
+	defer codecRegistry.Unlock()
+
+	if codec == nil {
+		delete(codecRegistry.byExt, ext)
+		return
+	}
+	codecRegistry.byExt[ext] = codec
+}
+
+// CodecForFilename returns the codec registered for the extension of filename, or nil
+// if there is none.
+func CodecForFilename(filename string) Codec {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return nil
+	}
+
+	codecRegistry.RLock()
+	defer codecRegistry.RUnlock()
+
+	return codecRegistry.byExt[ext]
+}
+
+// codecReader is an io.Reader that carries the codec that should be used to decode it.
+type codecReader struct {
+	io.Reader
+	codec Codec
+}
+
+// ReaderWithCodec wraps r so that Load() will decode it with codec rather than with the
+// codec passed to Load(). FindFiles does this automatically for files that have a
+// codec (either via FileLocation.Codec or RegisterCodec).
+func ReaderWithCodec(r io.Reader, codec Codec) io.Reader {
+	if codec == nil {
+		return r
+	}
+	return &codecReader{Reader: r, codec: codec}
+}
+
+// readerCodec returns the codec that r should be decoded with, falling back to dflt.
+func readerCodec(r io.Reader, dflt Codec) Codec {
+	if cr, ok := r.(*codecReader); ok {
+		return cr.codec
+	}
+	return dflt
+}
+
+// sameCodec returns true if a and b are the same codec. Codecs are typically empty
+// structs, but we can't assume that they're comparable.
+func sameCodec(a, b Codec) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if !reflect.TypeOf(a).Comparable() {
+		// We can't tell, so we'll err on the side of treating them as different
+		return false
+	}
+	return a == b
+}
+
+// translateConfigMap converts src, which was decoded by srcCodec, into a map that uses
+// the aliases of dstCodec, for the result struct type resultType. dstFields are the
+// struct fields of the result as dstCodec sees them. src must already have been verified
+// against the result's struct fields (as srcCodec sees them).
+// Integer values that a codec decoded as floats are converted back into integers, so
+// that they can be re-marshaled by a codec that is stricter about such things.
+func translateConfigMap(src map[string]interface{}, resultType reflect.Type, srcCodec, dstCodec Codec,
+	dstFields []*reflection.StructField) (map[string]interface{}, error) {
+	dst := make(map[string]interface{})
+	dstWriter := newMapWriter(dst, newFieldIndex(dstFields))
+	translator := newKeyTranslator(srcCodec, dstCodec)
+
+	mapFields := reflection.GetStructFields(src, TagName, srcCodec)
+	for _, mapField := range mapFields {
+		if len(mapField.Children) > 0 {
+			// We only want to explicitly copy leaves
+			continue
+		}
+
+		// Plain maps don't have multiple aliases, so keyElem[0] is sufficient
+		val := valueAtKey(src, mapField.AliasedKey)
+		rawKey := make(Key, len(mapField.AliasedKey))
+		for i, keyElem := range mapField.AliasedKey {
+			rawKey[i] = keyElem[0]
+		}
+
+		key, valType := translator.translate(rawKey, resultType)
+		if valType != nil {
+			val = normalizeIntegers(val, valType.String())
+		}
+
+		if err := dstWriter.set(key, val); err != nil {
+			return nil, errors.Wrapf(err, "setting map value failed for key %v", key)
+		}
+	}
+
+	return dst, nil
+}
+
+// keyTranslator converts keys that use the aliases of one codec into keys that use the
+// aliases of another (see translate).
+type keyTranslator struct {
+	from, to Codec
+
+	// For each struct type visited: case-folded field name or from alias -> field
+	fields map[reflect.Type]map[string]reflect.StructField
+}
+
+func newKeyTranslator(from, to Codec) *keyTranslator {
+	return &keyTranslator{from: from, to: to, fields: make(map[reflect.Type]map[string]reflect.StructField)}
+}
+
+// translate converts rawKey, a key into a value of type t that uses the aliases of
+// kt.from, into one that uses the aliases of kt.to (or the struct field names, where
+// kt.to has no alias). If kt.to is nil, the struct field names are always used.
+// Unlike StructFields, this follows the element types of maps, so the fields of structs
+// within maps are translated too. Elements that don't correspond to struct fields (like
+// map keys) are left as they are. The type of the value at the key is also returned, or
+// nil if it can't be determined.
+func (kt *keyTranslator) translate(rawKey Key, t reflect.Type) (Key, reflect.Type) {
+	key := make(Key, len(rawKey))
+	for i, keyElem := range rawKey {
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		switch {
+		case t == nil:
+			// Beyond what we know the type of
+		case t.Kind() == reflect.Map:
+			// keyElem is a map key, which is kept as it is
+			t = t.Elem()
+		case t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType):
+			field, ok := kt.structFields(t)[foldKey(keyElem)]
+			if !ok {
+				t = nil
+				break
+			}
+			keyElem = field.Name
+			if kt.to != nil {
+				if alias := kt.to.GetStructFieldAlias(field.Tag); alias != "" {
+					keyElem = alias
+				}
+			}
+			t = field.Type
+		default:
+			t = nil
+		}
+
+		key[i] = keyElem
+	}
+
+	return key, t
+}
+
+// structFields returns the fields of the struct type t, keyed by their case-folded names
+// and kt.from aliases (with aliases taking precedence).
+func (kt *keyTranslator) structFields(t reflect.Type) map[string]reflect.StructField {
+	if fields, ok := kt.fields[t]; ok {
+		return fields
+	}
+
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || kt.from.IsStructFieldIgnored(field.Tag) {
+			continue
+		}
+		if _, exists := fields[foldKey(field.Name)]; !exists {
+			fields[foldKey(field.Name)] = field
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || kt.from.IsStructFieldIgnored(field.Tag) {
+			continue
+		}
+		if alias := kt.from.GetStructFieldAlias(field.Tag); alias != "" {
+			fields[foldKey(alias)] = field
+		}
+	}
+
+	kt.fields[t] = fields
+	return fields
+}
+
+// readerPositions gets the key positions in data, if rCodec supports it. Flat keys are
+// split so that they will match the keys of the map that Load() merges for the reader.
+// result is the struct that flat keys are split against; it is nil if the result is a map.
+func readerPositions(data []byte, rCodec Codec, result interface{}) ([]reflection.KeyPosition, error) {
+	posCodec, ok := rCodec.(PositionCodec)
+	if !ok {
+		return nil, nil
+	}
+
+	positions, err := posCodec.KeyPositions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	flatCodec, ok := rCodec.(FlatCodec)
+	if result == nil || !ok {
+		return positions, nil
+	}
+
+	for i := range positions {
+		if key := positions[i].Key; len(key) == 1 {
+			if split := untyped.SplitFlatKey(key[0], flatCodec.KeySeparator(), result, rCodec); split != nil {
+				positions[i].Key = split
+			}
+		}
+	}
+
+	return positions, nil
+}
+
+// translatePositions returns a copy of positions (from a reader using codec from) with
+// keys that use the aliases of codec to (see keyTranslator), so that they match the keys
+// that the reader's values are merged with.
+func translatePositions(positions []reflection.KeyPosition, resultType reflect.Type, from, to Codec) []reflection.KeyPosition {
+	translator := newKeyTranslator(from, to)
+	translated := make([]reflection.KeyPosition, len(positions))
+	for i, p := range positions {
+		translated[i] = p
+		translated[i].Key, _ = translator.translate(p.Key, resultType)
+	}
+	return translated
+}
+
+// normalizeIntegers converts integral float64 values (or slices of them) into int64 if
+// goType (like "int" or "[]uint16") indicates that the target is an integer type.
+func normalizeIntegers(val interface{}, goType string) interface{} {
+	goType = strings.TrimLeft(goType, "*")
+
+	if strings.HasPrefix(goType, "[]") {
+		slice, ok := val.([]interface{})
+		if !ok {
+			return val
+		}
+		elemType := goType[len("[]"):]
+		normSlice := make([]interface{}, len(slice))
+		for i := range slice {
+			normSlice[i] = normalizeIntegers(slice[i], elemType)
+		}
+		return normSlice
+	}
+
+	if !strings.HasPrefix(goType, "int") && !strings.HasPrefix(goType, "uint") {
+		return val
+	}
+
+	if f, ok := val.(float64); ok && f == math.Trunc(f) {
+		return int64(f)
+	}
+
+	return val
+}
+
+// convertUntypedStrings converts the string leaves in m (which was decoded by codec)
+// into values with types suitable for the corresponding fields in structFields.
+// Strings with no corresponding field are left unchanged.
+func convertUntypedStrings(m map[string]interface{}, codec UntypedCodec, structFields []*reflection.StructField) error {
+	fields := newFieldIndex(structFields)
+	mapFields := reflection.GetStructFields(m, TagName, codec)
+	for _, mapField := range mapFields {
+		if len(mapField.Children) > 0 || mapField.Kind != "string" {
+			// Not a string leaf
+			continue
+		}
+
+		sf, exact := fields.find(mapField.AliasedKey)
+		if sf == nil || (!exact && sf.Kind != "map") {
+			// The vestigial check will catch this
+			continue
+		}
+
+		// Find the map containing the value
+		currMap := m
+		for _, keyElem := range mapField.AliasedKey[:len(mapField.AliasedKey)-1] {
+			// Plain maps don't have multiple aliases, so keyElem[0] is sufficient
+			currMap = currMap[keyElem[0]].(map[string]interface{})
+		}
+		leafKey := mapField.AliasedKey[len(mapField.AliasedKey)-1][0]
+
+		converted, err := codec.ConvertString(currMap[leafKey].(string), sf)
+		if err != nil {
+			return &FieldError{
+				Key: keyFromAliasedKey(mapField.AliasedKey),
+				Err: errors.Wrap(err, "ConvertString failed"),
+			}
+		}
+		currMap[leafKey] = converted
+	}
+
+	return nil
+}
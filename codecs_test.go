@@ -0,0 +1,278 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+	"github.com/pkg/errors"
+)
+
+func TestCodecForFilename(t *testing.T) {
+	RegisterCodec(".toml", toml.Codec)
+	RegisterCodec("json", json.Codec)
+	defer func() {
+		RegisterCodec(".toml", nil)
+		RegisterCodec(".json", nil)
+	}()
+
+	tests := []struct {
+		filename string
+		want     Codec
+	}{
+		{"config.toml", toml.Codec},
+		{"path/to/config.TOML", toml.Codec},
+		{"config.json", json.Codec},
+		{"config.yaml", nil},
+		{"config", nil},
+		{"toml", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := CodecForFilename(tt.filename); got != tt.want {
+				t.Fatalf("CodecForFilename() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	RegisterCodec(".toml", nil)
+	if got := CodecForFilename("config.toml"); got != nil {
+		t.Fatalf("codec should have been unregistered; got %#v", got)
+	}
+}
+
+func TestFindFiles_Codecs(t *testing.T) {
+	RegisterCodec(".json", json.Codec)
+	defer RegisterCodec(".json", nil)
+
+	fsys := fstest.MapFS{
+		"config.toml":          {Data: []byte("")},
+		"config_override.json": {Data: []byte("")},
+		"config_extra.conf":    {Data: []byte("")},
+	}
+
+	readers, closers, _, err := FindFilesFS(fsys,
+		FileLocation{Filename: "config.toml", SearchPaths: []string{"."}},
+		FileLocation{Filename: "config_override.json", SearchPaths: []string{"."}},
+		FileLocation{Filename: "config_extra.conf", SearchPaths: []string{"."}, Codec: toml.Codec},
+	)
+	if err != nil {
+		t.Fatalf("FindFilesFS failed: %v", err)
+	}
+	defer func() {
+		for i := range closers {
+			closers[i].Close()
+		}
+	}()
+
+	wantCodecs := []Codec{nil, json.Codec, toml.Codec}
+	for i := range readers {
+		var gotCodec Codec
+		if cr, ok := readers[i].(*codecReader); ok {
+			gotCodec = cr.codec
+		}
+		if gotCodec != wantCodecs[i] {
+			t.Fatalf("reader %d codec mismatch; got %#v, want %#v", i, gotCodec, wantCodecs[i])
+		}
+	}
+}
+
+func TestLoad_MixedCodecs(t *testing.T) {
+	type config struct {
+		Server struct {
+			ListenPort int    `toml:"listen_port" json:"listenPort"`
+			Name       string `toml:"name" json:"serverName"`
+			Ports      []int  `toml:"ports" json:"ports" conf:"optional"`
+		} `toml:"server" json:"server"`
+		Log struct {
+			Level string `toml:"level" json:"level"`
+		} `toml:"log" json:"logging"`
+		Extra map[string]interface{} `toml:"extra" json:"extra" conf:"optional"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+		[server]
+		listen_port = 80
+		name = "from toml"
+		[log]
+		level = "info"
+		[extra]
+		a = "from toml"
+		`),
+		ReaderWithCodec(strings.NewReader(`{
+			"server": {"listenPort": 8080, "ports": [1, 2]},
+			"logging": {"level": "debug"},
+			"extra": {"b": "from json"}
+		}`), json.Codec),
+	}
+
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"config.toml", "override.json"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Server.ListenPort = 8080
+	want.Server.Name = "from toml"
+	want.Server.Ports = []int{1, 2}
+	want.Log.Level = "debug"
+	want.Extra = map[string]interface{}{
+		"a": "from toml",
+		"b": "from json",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	compareProvenances(t, md.Provenances, map[string]string{
+		"server.listen_port": "override.json",
+		"server.name":        "config.toml",
+		"server.ports":       "override.json",
+		"log.level":          "override.json",
+		"extra.a":            "config.toml",
+		"extra.b":            "override.json",
+	})
+
+	// Vestigial fields in the foreign-codec reader must still be detected, using its aliases
+	readers = []io.Reader{
+		strings.NewReader(`
+		[server]
+		listen_port = 80
+		name = "from toml"
+		[log]
+		level = "info"
+		`),
+		ReaderWithCodec(strings.NewReader(`{"server": {"listen_port": 8080}}`), json.Codec),
+	}
+	_, err = Load(toml.Codec, readers, nil, nil, nil, &config{})
+	if err == nil {
+		t.Fatalf("Load should fail for a key using another codec's alias")
+	}
+}
+
+// The aliases of the fields of structs within maps are translated too
+func TestLoad_MixedCodecsMapOfStruct(t *testing.T) {
+	type backend struct {
+		URL    string `toml:"url" json:"u"`
+		Weight uint8  `toml:"weight" json:"w"`
+	}
+	type config struct {
+		Backends map[string]backend `toml:"backends" json:"backends"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+		[backends.us]
+		url = "x"
+		weight = 1
+		`),
+		ReaderWithCodec(strings.NewReader(`{"backends": {"eu": {"u": "y", "w": 2}}}`), json.Codec),
+	}
+
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"a.toml", "b.json"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := config{Backends: map[string]backend{
+		"us": {URL: "x", Weight: 1},
+		"eu": {URL: "y", Weight: 2},
+	}}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	compareProvenances(t, md.Provenances, map[string]string{
+		"backends.us.url":    "a.toml",
+		"backends.us.weight": "a.toml",
+		"backends.eu.url":    "b.json",
+		"backends.eu.weight": "b.json",
+	})
+}
+
+// scanFailCodec decodes like the codec it wraps, but can't find key positions, like a
+// position scanner given a document that it doesn't understand.
+type scanFailCodec struct {
+	Codec
+}
+
+func (scanFailCodec) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	return nil, errors.New("line 1: expected key = value")
+}
+
+func TestLoad_KeyPositionsFail(t *testing.T) {
+	defer func() { Logger = nil }()
+
+	var buf bytes.Buffer
+	Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	type config struct {
+		Name string `toml:"name"`
+	}
+
+	var result config
+	codec := scanFailCodec{toml.Codec}
+	md, err := Load(codec, []io.Reader{strings.NewReader(`name = "x"`)}, []string{"a.toml"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if result.Name != "x" {
+		t.Fatalf("result mismatch: %+v", result)
+	}
+
+	// The provenance has no position
+	if len(md.Provenances) != 1 || md.Provenances[0].Src != "a.toml" ||
+		md.Provenances[0].Line != 0 || md.Provenances[0].Column != 0 {
+		t.Fatalf("provenance mismatch: %+v", md.Provenances)
+	}
+
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), "expected key = value") {
+		t.Fatalf("expected warning about key positions; got %q", buf.String())
+	}
+
+	// Errors still report the source, without a position
+	_, err = Load(codec, []io.Reader{strings.NewReader(`name = 1`)}, []string{"a.toml"}, nil, nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "a.toml") {
+		t.Fatalf("expected error with source; got %v", err)
+	}
+}
+
+func Test_normalizeIntegers(t *testing.T) {
+	tests := []struct {
+		name   string
+		val    interface{}
+		goType string
+		want   interface{}
+	}{
+		{"int", float64(3), "int", int64(3)},
+		{"uint8 pointer", float64(3), "*uint8", int64(3)},
+		{"non-integral", float64(3.5), "int", float64(3.5)},
+		{"float target", float64(3), "float64", float64(3)},
+		{"string", "3", "int", "3"},
+		{"slice", []interface{}{float64(1), float64(2)}, "[]int", []interface{}{int64(1), int64(2)}},
+		{"slice of float", []interface{}{float64(1)}, "[]float32", []interface{}{float64(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeIntegers(tt.val, tt.goType); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("normalizeIntegers() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
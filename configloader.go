@@ -10,12 +10,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/untyped"
 	"github.com/pkg/errors"
 )
 
@@ -82,6 +85,51 @@ type Default struct {
 	Val interface{}
 }
 
+// DefaultsFromDocument creates defaults from a complete config document (such as a TOML
+// or JSON file, possibly obtained via go:embed), decoded with codec. Each leaf value in
+// the document becomes a Default, so the result gets the same treatment as defaults
+// written in code: the fields are implicitly optional, are type-checked against the
+// result struct, and have a provenance of "[default]".
+//
+// The result can be combined with other defaults; later defaults for the same key take
+// precedence.
+func DefaultsFromDocument(codec Codec, data []byte) ([]Default, error) {
+	var docMap map[string]interface{}
+	if err := codec.Unmarshal(data, &docMap); err != nil {
+		return nil, errors.Wrap(err, "codec.Unmarshal failed for defaults document")
+	}
+
+	return defaultsFromMap(nil, docMap), nil
+}
+
+// Recursion helper for DefaultsFromDocument. Returns a Default for each leaf in m, with
+// keys prefixed by keyPrefix. Keys are sorted, to keep the results deterministic.
+func defaultsFromMap(keyPrefix Key, m map[string]interface{}) []Default {
+	mapKeys := make([]string, 0, len(m))
+	for k := range m {
+		mapKeys = append(mapKeys, k)
+	}
+	sort.Strings(mapKeys)
+
+	var defaults []Default
+	for _, k := range mapKeys {
+		key := make(Key, len(keyPrefix), len(keyPrefix)+1)
+		copy(key, keyPrefix)
+		key = append(key, k)
+
+		if subMap, ok := m[k].(map[string]interface{}); ok && len(subMap) > 0 {
+			// Branch; recurse
+			defaults = append(defaults, defaultsFromMap(key, subMap)...)
+			continue
+		}
+
+		// Leaf (possibly an empty map)
+		defaults = append(defaults, Default{Key: key, Val: m[k]})
+	}
+
+	return defaults
+}
+
 // Provenance indicates the source that the value of a field ultimately came from.
 type Provenance struct {
 	// We store aliasedKey as well as Key for the purposes of accessing and printing by caller
@@ -96,9 +144,53 @@ type Provenance struct {
 	//   "[default]": If the field received the default value passed to Load()
 	//   "[absent]": If the field was not set at all
 	//   "$ENV_VAR_NAME": If the field value came from an environment variable override
+	// The same information is available in structured form in Kind, Name, and Index.
 	Src string
+
+	// The kind of source that the value of the field came from.
+	Kind SourceKind
+
+	// For SourceFile, the reader name passed to Load() (empty if readerNames was nil).
+	// For SourceEnv, the environment variable name (without "$"). Otherwise empty.
+	Name string
+
+	// For SourceFile, the index of the reader passed to Load(). Otherwise zero.
+	Index int
+
+	// The 1-based line and column of the field within the file it came from. They are
+	// only set if the file's codec implements PositionCodec; otherwise they are zero.
+	Line, Column int
+
+	// Every source that supplied a value for the field, in the order they were applied.
+	// The last one is the source of the final value (unless the field is absent, in which
+	// case History is empty).
+	History []ProvenanceLayer
 }
 
+// ProvenanceLayer is a value supplied for a field by one source (or layer) of config.
+type ProvenanceLayer struct {
+	// The source of the value, in the same form as Provenance.Src.
+	Src string
+
+	// The structured form of Src, as in Provenance.
+	Kind  SourceKind
+	Name  string
+	Index int
+
+	// The position of the value within Src, as with Provenance.Line and Provenance.Column.
+	Line, Column int
+
+	// The value supplied by the source. If the field is secret (flagged with
+	// `conf:"secret"`), this is RedactedValue instead. Val is left out when provenances
+	// are serialized with encoding/json, as config values may be sensitive even if they
+	// aren't flagged secret. (Provenance.LogValue does include the final value of fields
+	// that aren't secret.)
+	Val interface{} `json:"-"`
+}
+
+// RedactedValue replaces the values of secret fields in ProvenanceLayer.Val.
+const RedactedValue = "[redacted]"
+
 // Provenances provides the sources (provenances) for all of the fields in the resulting
 // struct or map.
 // It is good practice to log this value for later debugging help.
@@ -106,13 +198,32 @@ type Provenances []Provenance
 
 // Metadata contains information about the loaded config.
 type Metadata struct {
+	// The fields of the result struct. These may be shared with other Loads (see
+	// reflection.GetStructFieldsCached), so they must not be modified.
 	structFields []*reflection.StructField
 	absentFields []*reflection.StructField
 
+	// Fields that are optional for this Load, beyond those tagged optional (because they
+	// have defaults or are within an absent optional branch). Only used during Load().
+	madeOptional map[*reflection.StructField]bool
+
+	// An index of structFields
+	fields *fieldIndex
+
+	// The index in Provenances of each provenance, by canonical key. Only used (and only
+	// valid) during Load(), since Provenances may be re-ordered after that.
+	provIndex map[string]int
+
 	// A map version of the resulting config.
 	// It is good practice to log either this map or the config struct for later debugging help,
 	// BUT ONLY IF THEY DON'T CONTAIN SECRETS.
 	// (If the result is already a map, this is identical.)
+	// If the result is a struct, the values are taken from it, but have the types that the
+	// TOML and JSON codecs decode to, whatever the types of the struct fields: int64 for
+	// integers (uint64 for unsigned values too big for int64), float64 for floats, and
+	// string and bool. So a uint16 field is an int64, and a time.Duration field is its
+	// int64 number of nanoseconds. Values that implement encoding.TextMarshaler (like
+	// time.Time) keep their types.
 	ConfigMap map[string]interface{}
 
 	// The sources of each config field.
@@ -134,7 +245,7 @@ func (md *Metadata) IsDefined(key ...string) (bool, error) {
 
 	if len(md.structFields) > 0 {
 		// Result was a struct
-		sf, exact := findStructField(md.structFields, aliasedKey)
+		sf, exact := md.fields.find(aliasedKey)
 
 		// If it's not absent and it is in the struct, then it is defined.
 		if exact {
@@ -157,33 +268,179 @@ func (md *Metadata) IsDefined(key ...string) (bool, error) {
 	return false, errors.Errorf("key does not exist among known fields: %+v", md.structFields)
 }
 
-// Add or overwrite the provenance src for the given key
-func (md *Metadata) setProvenance(k Key, src string) {
-	ak := aliasedKeyFromKey(k)
+// isOptional returns true if sf is tagged optional or has been made optional during Load.
+func (md *Metadata) isOptional(sf *reflection.StructField) bool {
+	return sf.Optional || md.madeOptional[sf]
+}
 
-	// Try to find the full aliased key
-	if sf, exact := findStructField(md.structFields, ak); exact {
+// fullAliasedKey converts k into an aliased key. If k matches a struct field, the field's
+// full aliased key is used.
+func (md *Metadata) fullAliasedKey(k Key) reflection.AliasedKey {
+	ak := aliasedKeyFromKey(k)
+	if sf, exact := md.fields.find(ak); exact {
 		ak = sf.AliasedKey
 	}
+	return ak
+}
+
+// Add or overwrite the provenance src for the given key, and add val (the value supplied by
+// src) to its history. Any previous position is cleared.
+func (md *Metadata) setProvenance(k Key, src source, val interface{}) {
+	ak := md.fullAliasedKey(k)
+
+	layer := ProvenanceLayer{Src: src.String(), Kind: src.kind, Name: src.name, Index: src.index, Val: val}
+	if md.isSecret(ak) {
+		layer.Val = RedactedValue
+	}
 
 	// See if the new provenance is already in the slice (possibly with an alias)
-	for i := range md.Provenances {
-		if ak.Equal(md.Provenances[i].aliasedKey) {
-			// Already present; update
-			md.Provenances[i].Src = src
-			return
-		}
+	if i, ok := md.provenanceIndex(ak); ok {
+		// Already present; update
+		prov := &md.Provenances[i]
+		prov.Src, prov.Kind, prov.Name, prov.Index = layer.Src, layer.Kind, layer.Name, layer.Index
+		prov.Line, prov.Column = 0, 0
+		prov.History = append(prov.History, layer)
+		return
 	}
 
 	// This is a new one
 	prov := Provenance{
 		aliasedKey: ak,
 		Key:        keyFromAliasedKey(ak),
-		Src:        src,
+		Src:        layer.Src,
+		Kind:       layer.Kind,
+		Name:       layer.Name,
+		Index:      layer.Index,
+		History:    []ProvenanceLayer{layer},
+	}
+	md.addProvenance(prov)
+}
+
+// addProvenance adds prov to Provenances (and to provIndex, if it's in use).
+func (md *Metadata) addProvenance(prov Provenance) {
+	if md.provIndex != nil {
+		md.provIndex[md.fields.canonicalKey(prov.aliasedKey)] = len(md.Provenances)
 	}
 	md.Provenances = append(md.Provenances, prov)
 }
 
+// provenanceIndex returns the index in Provenances of the provenance for the full aliased
+// key ak.
+func (md *Metadata) provenanceIndex(ak reflection.AliasedKey) (int, bool) {
+	if md.provIndex != nil {
+		i, ok := md.provIndex[md.fields.canonicalKey(ak)]
+		return i, ok
+	}
+
+	for i := range md.Provenances {
+		if ak.Equal(md.Provenances[i].aliasedKey) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setProvenanceLeaves is like setProvenance, but if val is a non-empty map, provenance is
+// recorded for each of its leaves instead of for the map as a whole. This gives map entries
+// supplied by defaults and env overrides their own provenance, as entries from readers have.
+func (md *Metadata) setProvenanceLeaves(k Key, src source, val interface{}) {
+	m, ok := val.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		md.setProvenance(k, src, val)
+		return
+	}
+
+	// Sorted, so that the order of Provenances is stable
+	mapKeys := make([]string, 0, len(m))
+	for mk := range m {
+		mapKeys = append(mapKeys, mk)
+	}
+	sort.Strings(mapKeys)
+
+	for _, mk := range mapKeys {
+		entryKey := append(append(Key{}, k...), mk)
+		md.setProvenanceLeaves(entryKey, src, m[mk])
+	}
+}
+
+// setAbsentProvenance records that the field at k is absent.
+func (md *Metadata) setAbsentProvenance(k Key) {
+	ak := md.fullAliasedKey(k)
+	md.addProvenance(Provenance{
+		aliasedKey: ak,
+		Key:        keyFromAliasedKey(ak),
+		Src:        source{kind: SourceAbsent}.String(),
+		Kind:       SourceAbsent,
+	})
+}
+
+// isSecret returns true if the field at ak (or the map it's within) is flagged as secret.
+func (md *Metadata) isSecret(ak reflection.AliasedKey) bool {
+	sf, _ := md.fields.find(ak)
+	return sf != nil && sf.Secret
+}
+
+// setProvenancePosition sets the line and column of the provenance for key k, if it can
+// be found in positions.
+func (md *Metadata) setProvenancePosition(k Key, positions *positionIndex) {
+	pos, found := positions.find(k)
+	if !found {
+		return
+	}
+
+	if i, ok := md.provenanceIndex(md.fullAliasedKey(k)); ok {
+		prov := &md.Provenances[i]
+		prov.Line, prov.Column = pos.Line, pos.Column
+		if len(prov.History) > 0 {
+			layer := &prov.History[len(prov.History)-1]
+			layer.Line, layer.Column = pos.Line, pos.Column
+		}
+	}
+}
+
+// Explain describes, for humans, how the value of the field at key was arrived at: each
+// source that supplied a value, in order, with the value it supplied (secret values are
+// redacted). For example:
+//  log.level:
+//    [default]: "info"
+//    config.toml:12:1: "debug"
+//    $LOG_LEVEL: "warn" (final)
+func (md *Metadata) Explain(key ...string) string {
+	ak := md.fullAliasedKey(key)
+
+	for _, prov := range md.Provenances {
+		if !ak.Equal(prov.aliasedKey) {
+			continue
+		}
+
+		if len(prov.History) == 0 {
+			return fmt.Sprintf("%s: %s", prov.Key, prov.Src)
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s:", prov.Key)
+		for i, layer := range prov.History {
+			src := layer.Src
+			if layer.Line > 0 {
+				src = fmt.Sprintf("%s:%d:%d", src, layer.Line, layer.Column)
+			}
+
+			val := fmt.Sprintf("%v", layer.Val)
+			if s, ok := layer.Val.(string); ok && s != RedactedValue {
+				val = strconv.Quote(s)
+			}
+
+			fmt.Fprintf(&sb, "\n  %s: %s", src, val)
+			if i == len(prov.History)-1 {
+				sb.WriteString(" (final)")
+			}
+		}
+		return sb.String()
+	}
+
+	return fmt.Sprintf("%s: no provenance (not a known field)", Key(key))
+}
+
 // String converts the provenance to a string. Useful for debugging, logging, or examples.
 func (prov Provenance) String() string {
 	return fmt.Sprintf("'%s':'%s'", prov.Key, prov.Src)
@@ -208,6 +465,9 @@ func (provs Provenances) String() string {
 // deeper helpers that do.
 type decoder struct {
 	codec Codec
+
+	// Where warnings are logged; if nil, the Logger variable is used
+	logger *slog.Logger
 }
 
 // Load gathers config data from readers, defaults, and environment overrides, and
@@ -218,7 +478,11 @@ type decoder struct {
 // implementation, but you probably want to use one of the configloader-go sub-packages (like json or toml).
 //
 // readers will be used to populate the config. Later readers in the slice will take
-// precedence and values from them will clobber the earlier.
+// precedence and values from them will clobber the earlier. Readers are decoded with
+// codec, unless they have their own codec attached (see ReaderWithCodec and
+// RegisterCodec). This allows config files of different formats to be mixed. In that
+// case codec is still used for the struct aliases of the result (like `toml:"alias"`)
+// and for populating the result, so it should be the format of the primary config.
 //
 // readerNames contains useful names for the readers. This is intended to be the filenames
 // obtained from FindFiles(). This is partly a human-readable convenience for provenances
@@ -242,7 +506,15 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 ) (
 	md Metadata, err error,
 ) {
-	decoder := decoder{codec}
+	return load(decoder{codec: codec}, readers, readerNames, defaults, envOverrides, result)
+}
+
+// Implementation of Load and LoadInto. decoder holds the codec and logger.
+func load(decoder decoder, readers []io.Reader, readerNames []string, defaults []Default, envOverrides []EnvOverride, result interface{},
+) (
+	md Metadata, err error,
+) {
+	codec := decoder.codec
 
 	if readerNames != nil && len(readerNames) != len(readers) {
 		return md, errors.New("readerNames must be nil or the same length as readers")
@@ -260,7 +532,14 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 
 	// Get info about the struct being populated. If result is actually a map and not a
 	// struct, this will be empty.
-	md.structFields = reflection.GetStructFields(result, TagName, codec)
+	md.structFields = reflection.GetStructFieldsCached(result, TagName, codec)
+	md.fields = newFieldIndex(md.structFields)
+
+	md.madeOptional = make(map[*reflection.StructField]bool)
+	defer func() { md.madeOptional = nil }()
+
+	md.provIndex = make(map[string]int)
+	defer func() { md.provIndex = nil }()
 
 	// We'll use this to build up the combined config map
 	accumConfigMap := make(map[string]interface{})
@@ -270,19 +549,20 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 	//
 
 	// The presence of a default value for a field implies that the field is optional.
-	// Update the structFields appropriately.
+	// Record that in md.madeOptional.
 	defaultsMap := make(map[string]interface{})
+	defaultsWriter := newMapWriter(defaultsMap, md.fields)
 	for _, dflt := range defaults {
 		// If we're setting into a struct (vs a map), make sure the key is valid
 		if !resultIsMap {
-			sf, exact := findStructField(md.structFields, aliasedKeyFromKey(dflt.Key))
+			sf, exact := md.fields.find(aliasedKeyFromKey(dflt.Key))
 			if sf == nil {
 				return md, errors.Errorf("defaults key not found in struct: %+v", dflt)
 			}
 
 			if exact {
 				// Because a default was supplied, assume this field is optional
-				sf.Optional = true
+				md.madeOptional[sf] = true
 
 				// Convert the key into one that prefers aliases
 				dflt.Key = keyFromAliasedKey(sf.AliasedKey)
@@ -295,24 +575,30 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 			}
 		}
 
-		if err := setMapByKey(defaultsMap, dflt.Key, dflt.Val, md.structFields); err != nil {
-			return md, errors.Wrapf(err, "setMapByKey failed for default: %+v", dflt)
+		if err := defaultsWriter.set(dflt.Key, dflt.Val); err != nil {
+			return md, errors.Wrapf(err, "setting map value failed for default: %+v", dflt)
 		}
 
-		md.setProvenance(dflt.Key, "[default]")
+		md.setProvenanceLeaves(dflt.Key, source{kind: SourceDefault}, dflt.Val)
 	}
 
 	if !resultIsMap {
 		// We ignore absentFields for now. Just checking types and vestigials.
 		_, err = decoder.verifyFieldsConsistency(
 			reflection.GetStructFields(defaultsMap, TagName, codec), md.structFields)
+		if err == nil {
+			err = checkValueRanges(defaultsMap, codec, md.structFields)
+		}
 		if err != nil {
-			return md, errors.Wrapf(err, "verifyFieldsConsistency failed for defaults")
+			if withSource(err, "[default]", nil, nil) {
+				return md, err
+			}
+			return md, errors.Wrapf(err, "field verification failed for defaults")
 		}
 	}
 
 	// Merge the defaults map into the accum map (contributor updating happened above)
-	decoder.mergeMaps(accumConfigMap, defaultsMap, md.structFields)
+	decoder.mergeMaps(accumConfigMap, defaultsMap, md.fields)
 
 	//
 	// Readers
@@ -320,10 +606,15 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 
 	// Get the config (file) data from the readers
 	for i, r := range readers {
-		readerName := fmt.Sprintf("[%d]", i)
+		readerSource := source{kind: SourceFile, index: i}
 		if len(readerNames) > i {
-			readerName = readerNames[i]
+			readerSource.name = readerNames[i]
 		}
+		readerName := readerSource.String()
+
+		// The reader may have its own codec (e.g., if it came from FindFiles)
+		rCodec := readerCodec(r, codec)
+		rIsForeign := !sameCodec(rCodec, codec)
 
 		b, err := ioutil.ReadAll(r)
 		if err != nil {
@@ -331,24 +622,80 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 		}
 
 		var newConfigMap map[string]interface{}
-		err = codec.Unmarshal(b, &newConfigMap)
+		err = rCodec.Unmarshal(b, &newConfigMap)
 		if err != nil {
 			return md, errors.Wrapf(err, "codec.Unmarshal failed for config reader '%s'", readerName)
 		}
 
+		// If the reader uses a different codec, its keys will use that codec's
+		// aliases, so we need to check against the struct as that codec sees it.
+		rDecoder := decoder
+		rStructFields := md.structFields
+		if rIsForeign && !resultIsMap {
+			rDecoder.codec = rCodec
+			rStructFields = reflection.GetStructFieldsCached(result, TagName, rCodec)
+		}
+
+		var splitResult interface{}
+		if !resultIsMap {
+			splitResult = result
+		}
+		positions, err := readerPositions(b, rCodec, splitResult)
+		if err != nil {
+			// Positions are only used to improve errors and provenances, so a document
+			// that the codec can decode but not scan shouldn't stop it from loading
+			logWarn(decoder.logger, "configloader: key positions unavailable for config reader",
+				"reader", readerName, "error", err.Error())
+			positions, err = nil, nil
+		}
+
 		if !resultIsMap {
+			// Flat keys need to be mapped onto the struct before anything else
+			if flatCodec, ok := rCodec.(FlatCodec); ok {
+				newConfigMap, err = untyped.NestFlatKeys(newConfigMap, flatCodec.KeySeparator(), result, rCodec)
+				if err != nil {
+					return md, errors.Wrapf(err, "NestFlatKeys failed for config reader '%s'", readerName)
+				}
+			}
+
+			// Values from untyped codecs need to be converted before their types are checked
+			if untypedCodec, ok := rCodec.(UntypedCodec); ok {
+				err = convertUntypedStrings(newConfigMap, untypedCodec, rStructFields)
+			}
+
 			// We ignore absentFields for now. Just checking types and vestigials.
-			_, err = decoder.verifyFieldsConsistency(
-				reflection.GetStructFields(newConfigMap, TagName, codec), md.structFields)
+			if err == nil {
+				_, err = rDecoder.verifyFieldsConsistency(
+					reflection.GetStructFields(newConfigMap, TagName, rCodec), rStructFields)
+			}
+
+			if err == nil {
+				err = checkValueRanges(newConfigMap, rCodec, rStructFields)
+			}
+
 			if err != nil {
-				return md, errors.Wrapf(err, "verifyFieldsConsistency failed for config reader '%s'", readerName)
+				if withSource(err, readerName, positions, rStructFields) {
+					return md, err
+				}
+				return md, errors.Wrapf(err, "field verification failed for config reader '%s'", readerName)
+			}
+
+			if rIsForeign {
+				// Reconcile the aliases (and values) with those of the main codec
+				newConfigMap, err = translateConfigMap(newConfigMap, reflectResult.Elem().Type(), rCodec, codec, md.structFields)
+				if err != nil {
+					return md, errors.Wrapf(err, "translateConfigMap failed for config reader '%s'", readerName)
+				}
+				positions = translatePositions(positions, reflectResult.Elem().Type(), rCodec, codec)
 			}
 		}
 
 		// Merge the new map into the accum map, and collect contributor info
-		keysMerged := decoder.mergeMaps(accumConfigMap, newConfigMap, md.structFields)
+		keysMerged := decoder.mergeMaps(accumConfigMap, newConfigMap, md.fields)
+		posIndex := newPositionIndex(positions, md.fields)
 		for _, k := range keysMerged {
-			md.setProvenance(k, readerName)
+			md.setProvenance(k, readerSource, valueAtKey(newConfigMap, aliasedKeyFromKey(k)))
+			md.setProvenancePosition(k, posIndex)
 		}
 	}
 
@@ -358,10 +705,11 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 
 	// Now add in the environment var overrides
 	envMap := make(map[string]interface{})
+	envWriter := newMapWriter(envMap, md.fields)
 	for _, eo := range envOverrides {
 		// If we're setting into a struct (vs a map), make sure the key is valid
 		if !resultIsMap {
-			sf, exact := findStructField(md.structFields, aliasedKeyFromKey(eo.Key))
+			sf, exact := md.fields.find(aliasedKeyFromKey(eo.Key))
 			if sf == nil {
 				return md, errors.Errorf("envOverride key not found in struct: %+v", eo)
 			}
@@ -391,24 +739,31 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 			}
 		}
 
-		if err := setMapByKey(envMap, eo.Key, valI, md.structFields); err != nil {
-			return md, errors.Wrapf(err, "setMapByKey failed for envOverride: %+v", eo)
+		if err := envWriter.set(eo.Key, valI); err != nil {
+			return md, errors.Wrapf(err, "setting map value failed for envOverride: %+v", eo)
 		}
 
-		md.setProvenance(eo.Key, "$"+eo.EnvVar)
+		md.setProvenanceLeaves(eo.Key, source{kind: SourceEnv, name: eo.EnvVar}, valI)
 	}
 
 	if !resultIsMap {
 		// We ignore absentFields for now. Just checking types and vestigials.
 		_, err = decoder.verifyFieldsConsistency(
 			reflection.GetStructFields(envMap, TagName, codec), md.structFields)
+		if err == nil {
+			err = checkValueRanges(envMap, codec, md.structFields)
+		}
 		if err != nil {
-			return md, errors.Wrapf(err, "verifyFieldsConsistency failed for env overrides")
+			if fe, ok := err.(*FieldError); ok {
+				fe.Src = envVarForKey(fe.Key, envOverrides, md.structFields)
+				return md, fe
+			}
+			return md, errors.Wrapf(err, "field verification failed for env overrides")
 		}
 	}
 
 	// Merge the env map into the accum map (contributor updating happened above)
-	decoder.mergeMaps(accumConfigMap, envMap, md.structFields)
+	decoder.mergeMaps(accumConfigMap, envMap, md.fields)
 
 	//
 	// Finalize
@@ -421,7 +776,7 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 		if *resultMap == nil {
 			*resultMap = make(map[string]interface{})
 		}
-		decoder.mergeMaps(*resultMap, accumConfigMap, md.structFields)
+		decoder.mergeMaps(*resultMap, accumConfigMap, md.fields)
 		md.ConfigMap = *resultMap
 		return md, nil
 	}
@@ -439,24 +794,24 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 	for _, f := range md.absentFields {
 		// We only record provenance for leafs
 		if len(f.Children) == 0 {
-			md.setProvenance(keyFromAliasedKey(f.AliasedKey), "[absent]")
+			md.setAbsentProvenance(keyFromAliasedKey(f.AliasedKey))
 		}
 
 		// If a branch of the tree (struct or map) is optional and absent, then its
 		// children will not be considered "required". But if that optional branch is
 		// present, then its children must adhere to their own optional status.
-		if f.Optional && len(f.Children) > 0 {
+		if md.isOptional(f) && len(f.Children) > 0 {
 			// This field is absent, optional, and has children (so it's a branch).
 			// Mark all of its children and grandchildren as optional as well, so they
 			// don't get flagged as "required".
 			// Note that if some children are themselves branches, when they get processed
-			// their children will get marked Optional, and so on.
+			// their children will get marked optional, and so on.
 			for _, child := range f.Children {
-				child.Optional = true
+				md.madeOptional[child] = true
 			}
 		}
 
-		if !f.Optional {
+		if !md.isOptional(f) {
 			missingRequiredFields = append(missingRequiredFields, f)
 		}
 	}
@@ -465,90 +820,24 @@ func Load(codec Codec, readers []io.Reader, readerNames []string, defaults []Def
 	}
 
 	// We now have a map populated with all of our data, including env overrides.
-	// Marshal it and then re-unmarshal it into the destination struct.
-	buf, err := codec.Marshal(accumConfigMap)
-	if err != nil {
-		return md, errors.Wrap(err, "Re-marshaling accumulated config map failed")
-	}
-	err = codec.Unmarshal(buf, result)
-	if err != nil {
-		return md, errors.Wrap(err, "Failed to unmarshal result struct")
-	}
-
-	// In order to populate Metadata.ConfigMap, we need to marshal our final struct and
-	// then unmarshal it into a map. The reason we can't just use accumConfigMap is that
-	// there may have been values already set into the result struct and we can't get at
-	// them without going the long way.
-	buf, err = codec.Marshal(result)
-	if err != nil {
-		return md, errors.Wrap(err, "Failed to marshal final result struct")
-	}
-	err = codec.Unmarshal(buf, &md.ConfigMap)
+	// Assign it into the destination struct. This also gives us Metadata.ConfigMap, which
+	// needs to come from the final struct, as there may have been values already set into
+	// the result struct that weren't in accumConfigMap.
+	md.ConfigMap, err = decoder.decodeInto(accumConfigMap, result)
 	if err != nil {
-		return md, errors.Wrap(err, "Failed to unmarshal final config map")
+		return md, errors.Wrap(err, "Failed to decode into result struct")
 	}
 
 	return md, nil
 }
 
-func setMapByKey(m map[string]interface{}, k Key, v interface{}, structFields []*reflection.StructField) error {
-	aliasedKey := aliasedKeyFromKey(k)
-
-	// We'll try to find an AliasedKey from the provided struct fields (if any). If we
-	// can't find a full match, we'll look for prefixes, as we might be settings a
-	// map-within-a-struct, that only has struct fields up to a certain point, and that
-	// we still want to match.
-	if sf, _ := findStructField(structFields, aliasedKey); sf != nil {
-		// Found a match. May be a prefix.
-		// Combine this prefix with the rest of the original key.
-		aliasedKey = append(sf.AliasedKey, aliasedKey[len(sf.AliasedKey):]...)
-	}
-
-	currMap := m
-	for i := range aliasedKey {
-		// The input key might be using struct field names rather than aliases, which
-		// will result in the final unmarshaling not finding those fields. So we'll
-		// prefer to use the alias, which is the last element of AliasedKeyElem.
-		keyElem := aliasedKey[i][len(aliasedKey[i])-1]
-
-		// If the field already exists in the map, use the key/field that's there,
-		// otherwise build the map at keyElem.
-		for currMapKey := range currMap {
-			if aliasedKey[i].Equal(reflection.AliasedKeyElem{currMapKey}) {
-				keyElem = currMapKey
-				break
-			}
-		}
-
-		// We're either at the leaf or at an intermediate node.
-		if i == len(aliasedKey)-1 {
-			// Leaf
-			currMap[keyElem] = v
-			break
-		}
-
-		// Intermediate. Make sure it's a map.
-		if currMap[keyElem] == nil {
-			// Either it doesn't exist or it exists and is nil
-			currMap[keyElem] = make(map[string]interface{})
-		} else if _, ok := currMap[keyElem].(map[string]interface{}); !ok {
-			// The map key exists, but is not itself a map. Not okay.
-			return errors.Errorf("Map subtree is not a map; full key: %+v; map subtree key: %+v; map: %+v", k, k[:i+1], m)
-		}
-
-		// Get the sub-map for the next iteration of the loop
-		currMap = currMap[keyElem].(map[string]interface{})
-	}
-
-	return nil
-}
-
 // Merge src into dst, overwriting values.
 // The keys of the leaves merged are returned.
-func (d decoder) mergeMaps(dst, src map[string]interface{}, structFields []*reflection.StructField) (keysMerged []Key) {
-	// Get all the fields of the src map
+func (d decoder) mergeMaps(dst, src map[string]interface{}, fields *fieldIndex) (keysMerged []Key) {
+	// Get all the fields of the src map. dst isn't scanned in the same way, as it's the
+	// accumulated config, which would make repeated merges quadratic.
 	srcStructFields := reflection.GetStructFields(src, TagName, d.codec)
-	dstStructFields := reflection.GetStructFields(dst, TagName, d.codec)
+	dstWriter := newMapWriter(dst, fields)
 
 	for i, srcField := range srcStructFields {
 		if srcField.Kind == "map" {
@@ -561,9 +850,11 @@ func (d decoder) mergeMaps(dst, src map[string]interface{}, structFields []*refl
 				continue
 			}
 
-			if _, exact := findStructField(dstStructFields, srcField.AliasedKey); exact {
+			if dstWriter.has(keyFromAliasedKey(srcField.AliasedKey)) {
 				// This map (or at least a field at this key) already exists in dst.
 				// We won't clobber it.
+				logWarn(d.logger, "configloader: empty map does not clear existing entries",
+					"key", keyFromAliasedKey(srcField.AliasedKey))
 				continue
 			}
 		}
@@ -584,7 +875,7 @@ func (d decoder) mergeMaps(dst, src map[string]interface{}, structFields []*refl
 		}
 
 		// This is a leaf
-		setMapByKey(dst, key, val, structFields)
+		dstWriter.set(key, val)
 		keysMerged = append(keysMerged, key)
 	}
 
@@ -598,53 +889,61 @@ func (d decoder) mergeMaps(dst, src map[string]interface{}, structFields []*refl
 // 3. Absent fields (both required and optional). Return this, but don't error on it.
 func (d decoder) verifyFieldsConsistency(check, gold []*reflection.StructField) (absentFields []*reflection.StructField, err error) {
 	// Start by treating all the gold fields as absent, then remove them as we hit them
-	absentFieldsCandidates := make([]*reflection.StructField, len(gold))
-	copy(absentFieldsCandidates, gold)
-
-	var skipPrefixes []reflection.AliasedKey
-
-CheckFieldsLoop:
-	for _, checkField := range check {
-		for _, skipPrefix := range skipPrefixes {
-			if checkField.AliasedKey.HasPrefix(skipPrefix) {
-				continue CheckFieldsLoop
+	goldFields := newFieldIndex(gold)
+	present := make(map[*reflection.StructField]bool)
+
+	// The canonical keys (see fieldIndex.canonicalKey) of the fields that we don't descend
+	// into, so that a field can be checked against them without scanning them all
+	skipPrefixes := make(map[string]bool)
+	skipped := func(ak reflection.AliasedKey) bool {
+		if len(skipPrefixes) == 0 {
+			return false
+		}
+		elems := goldFields.canonicalElems(ak)
+		for l := 1; l <= len(elems); l++ {
+			if skipPrefixes[joinCanonicalElems(elems[:l])] {
+				return true
 			}
 		}
+		return false
+	}
 
-		goldField, exact := findStructField(gold, checkField.AliasedKey)
-		if !exact {
-			return nil, errors.Errorf("field in config not found in struct: %+v", checkField)
+	for _, checkField := range check {
+		if skipped(checkField.AliasedKey) {
+			continue
 		}
 
-		// Remove goldField from absentFieldsCandidates
-		for i := range absentFieldsCandidates {
-			if absentFieldsCandidates[i].AliasedKey.Equal(goldField.AliasedKey) {
-				absentFieldsCandidates = append(absentFieldsCandidates[:i], absentFieldsCandidates[i+1:]...)
-				break
+		goldField, exact := goldFields.find(checkField.AliasedKey)
+		if !exact {
+			return nil, &FieldError{
+				Key: keyFromAliasedKey(checkField.AliasedKey),
+				Err: errors.Errorf("field in config not found in struct: %+v", checkField),
 			}
 		}
 
+		// goldField is not absent
+		present[goldField] = true
+
 		noDeeper, err := d.fieldTypesConsistent(checkField, goldField)
 		if err != nil {
-			return nil, errors.Wrapf(err, "field types not consistent; got %+v, want %+v", checkField, goldField)
+			return nil, &FieldError{
+				Key: keyFromAliasedKey(checkField.AliasedKey),
+				Err: errors.Wrapf(err, "field types not consistent; got %+v, want %+v", checkField, goldField),
+			}
 		}
 
 		if noDeeper {
-			skipPrefixes = append(skipPrefixes, checkField.AliasedKey)
+			skipPrefixes[goldFields.canonicalKey(checkField.AliasedKey)] = true
 		}
 	}
 
-	// Keys skipped due to skipPrefix do not cound as "absent", so remove any matches
-	// from absentFieldsCandidates that didn't get processed above.
+	// The gold fields that weren't present are absent. But keys skipped due to skipPrefix
+	// do not cound as "absent", so leave out any matches that didn't get processed above.
 	absentFields = make([]*reflection.StructField, 0)
-AbsentSkipLoop:
-	for _, absent := range absentFieldsCandidates {
-		for _, skipPrefix := range skipPrefixes {
-			if absent.AliasedKey.HasPrefix(skipPrefix) {
-				continue AbsentSkipLoop
-			}
+	for _, absent := range gold {
+		if present[absent] || skipped(absent.AliasedKey) {
+			continue
 		}
-		// Doesn't match any skip prefixes
 		absentFields = append(absentFields, absent)
 	}
 
@@ -659,11 +958,15 @@ func (d decoder) fieldTypesConsistent(check, gold *reflection.StructField) (noDe
 	/*
 		Examples:
 		- time.Time implements encoding.TextUnmarshaler, so expectedType will be "string"
+		  (but a TOML datetime will already be a time.Time, which is also fine)
 	*/
 
 	if gold.ExpectedType != "" {
-		// If a type is specified, then it must match exactly
-		if check.Type != gold.ExpectedType && check.Kind != gold.ExpectedType {
+		// If a type is specified, then it must match exactly. The exception is a struct
+		// value that is already of the field's type (like a TOML datetime for a
+		// time.Time), which decodeInto will assign as-is.
+		alreadyTyped := check.Kind == "struct" && check.Type == gold.Type
+		if check.Type != gold.ExpectedType && check.Kind != gold.ExpectedType && !alreadyTyped {
 			return false, errors.Errorf("check field type/kind does not match gold expected type; check:%+v; gold:%+v", check, gold)
 		}
 
@@ -700,17 +1003,21 @@ func (d decoder) fieldTypesConsistent(check, gold *reflection.StructField) (noDe
 		return noDeeper, nil
 	}
 
-	// We'll treat different int sizes as equivalent.
-	// If values are too big for specified types, an error will occur
-	// when unmarshalling.
-	if strings.HasPrefix(gold.Kind, "int") && strings.HasPrefix(check.Kind, "int") {
+	// For numeric checks, a pointer to a number is the same as the number
+	goldKind := gold.Kind
+	if goldKind == "ptr" {
+		goldKind = strings.TrimLeft(gold.Type, "*")
+	}
+
+	// We'll treat different int sizes, signed or unsigned, as equivalent.
+	// Values that don't fit in the specified types are caught by checkValueRanges.
+	if isIntegerKind(goldKind) && isIntegerKind(check.Kind) {
 		return noDeeper, nil
 	}
 
 	// We'll treat different float sizes as equivalent.
-	// If values are too big for specified types, an error will occur
-	// when unmarshalling.
-	if strings.HasPrefix(gold.Kind, "float") && strings.HasPrefix(check.Kind, "float") {
+	// Values that don't fit in the specified types are caught by checkValueRanges.
+	if strings.HasPrefix(goldKind, "float") && strings.HasPrefix(check.Kind, "float") {
 		return noDeeper, nil
 	}
 
@@ -730,38 +1037,20 @@ func (d decoder) fieldTypesConsistent(check, gold *reflection.StructField) (noDe
 	return false, errors.Errorf("check field type/kind does not match gold type/kind; check:%+v; gold:%+v", check, gold)
 }
 
+// isIntegerKind returns true if kind is a signed or unsigned integer kind, like "int8"
+// or "uint".
+func isIntegerKind(kind string) bool {
+	return strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint")
+}
+
 // findStructField finds the field at targetKey in fields. If the field is found at the
 // full key (i.e., the field is a struct field), exactMatch will be true. If a field is
 // found at the prefix of the key, it will be returned and exact will be false (the caller
 // may wish to check if the type of the prefix is a map).
 // If the key isn't found at all, then sf will nil.
 func findStructField(fields []*reflection.StructField, targetKey reflection.AliasedKey) (sf *reflection.StructField, exactMatch bool) {
-	// We'll try to find the key in the struct fields. If we can't find a full match,
-	// we'll look for prefixes, as we might be settings a map-within-a-struct, that only
-	// has struct fields up to a certain point, and that we still want to match.
-	targetKeyPrefix := targetKey
-	for len(targetKeyPrefix) > 0 {
-		for i := range fields {
-			fieldPtr := fields[i]
-			if len(fieldPtr.AliasedKey) != len(targetKeyPrefix) {
-				// Can't possibly match
-				continue
-			}
-
-			if targetKeyPrefix.Equal(fieldPtr.AliasedKey) {
-				// We found the field or a map prefix of it
-				sf = fieldPtr
-				exactMatch = (len(targetKeyPrefix) == len(targetKey))
-				return sf, exactMatch
-			}
-		}
-
-		// Didn't find it; try the next shorter prefix
-		targetKeyPrefix = targetKeyPrefix[:len(targetKeyPrefix)-1]
-	}
-
-	// We exhausted the search without a match
-	return nil, false
+	// For repeated lookups, create a fieldIndex once and use it instead
+	return newFieldIndex(fields).find(targetKey)
 }
 
 // Returns true if the given key exists in val, possibly deep. Returns false if the val
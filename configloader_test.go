@@ -9,6 +9,7 @@ package configloader
 import (
 	"encoding"
 	"io"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
@@ -21,7 +22,7 @@ import (
 	"github.com/Psiphon-Inc/configloader-go/toml"
 )
 
-func Test_setMapByKey(t *testing.T) {
+func Test_mapWriter_set(t *testing.T) {
 	// TODO: Tests that use structFields
 
 	type args struct {
@@ -146,9 +147,9 @@ func Test_setMapByKey(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := setMapByKey(tt.args.m, tt.args.k, tt.args.v, tt.args.structFields)
+			err := newMapWriter(tt.args.m, newFieldIndex(tt.args.structFields)).set(tt.args.k, tt.args.v)
 			if err != nil != tt.wantErr {
-				t.Fatalf("setMapByKey() error = %v, wantErr %v", err, tt.wantErr)
+				t.Fatalf("mapWriter.set() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			if err != nil {
@@ -162,6 +163,43 @@ func Test_setMapByKey(t *testing.T) {
 	}
 }
 
+func Test_mapWriter_has(t *testing.T) {
+	type strct struct {
+		Server struct {
+			ListenPort int `toml:"listen_port"`
+		} `toml:"server"`
+	}
+	fields := newFieldIndex(reflection.GetStructFields(&strct{}, TagName, toml.Codec))
+
+	m := map[string]interface{}{
+		"server": map[string]interface{}{"listen_port": 1},
+		"Labels": map[string]interface{}{},
+		"nil":    nil,
+	}
+
+	tests := []struct {
+		k    Key
+		want bool
+	}{
+		{Key{"server", "listen_port"}, true},
+		{Key{"Server", "ListenPort"}, true},
+		{Key{"SERVER"}, true},
+		{Key{"labels"}, true},
+		{Key{"nil"}, true},
+		{Key{"server", "host"}, false},
+		{Key{"labels", "a"}, false},
+		{Key{"nil", "a"}, false},
+		{Key{"server", "listen_port", "a"}, false},
+		{Key{"nope"}, false},
+	}
+	w := newMapWriter(m, fields)
+	for _, tt := range tests {
+		if got := w.has(tt.k); got != tt.want {
+			t.Fatalf("mapWriter.has(%v) = %v, want %v", tt.k, got, tt.want)
+		}
+	}
+}
+
 type textUnmarshalType struct {
 	ExportedString string
 	ExportedInt    int
@@ -211,6 +249,11 @@ func TestLoad(t *testing.T) {
 	type structWithTypedMap struct {
 		M map[string]int
 	}
+	type numbersStruct struct {
+		U uint64
+		I int64
+		F float32
+	}
 
 	type args struct {
 		codec        Codec
@@ -232,6 +275,7 @@ func TestLoad(t *testing.T) {
 	}
 	tests := make([]test, 0)
 	var tst test
+	var err error
 
 	//----------------------------------------------------------------------
 	tst = test{}
@@ -942,6 +986,52 @@ func TestLoad(t *testing.T) {
 
 	//----------------------------------------------------------------------
 
+	tst = test{}
+	tst.name = "json large integers"
+	tst.args.codec = json.Codec
+	tst.args.readers = makeStringReaders([]string{
+		`{"U": 18446744073709551615, "I": -9007199254740993, "F": 2}`,
+	})
+	tst.args.readerNames = []string{"first"}
+	tst.wantConfig = numbersStruct{U: math.MaxUint64, I: -9007199254740993, F: 2}
+	tst.wantProvenances = map[string]string{
+		"U": "first",
+		"I": "first",
+		"F": "first",
+	}
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
+	tst = test{}
+	tst.name = "json integral floats into integer fields"
+	tst.args.codec = json.Codec
+	tst.args.readers = makeStringReaders([]string{
+		`{"U": 1e3, "I": 8080.0, "F": 2.5}`,
+	})
+	tst.args.readerNames = []string{"first"}
+	tst.wantConfig = numbersStruct{U: 1000, I: 8080, F: 2.5}
+	tst.wantProvenances = map[string]string{
+		"U": "first",
+		"I": "first",
+		"F": "first",
+	}
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
+	tst = test{}
+	tst.name = "error: json non-integer into integer field"
+	tst.args.codec = json.Codec
+	tst.args.readers = makeStringReaders([]string{
+		`{"U": 1, "I": 1.5, "F": 2}`,
+	})
+	tst.wantConfig = numbersStruct{}
+	tst.wantErr = true
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
 	tst = test{}
 	tst.name = "error: multi-reader name mismatch"
 	tst.args.codec = toml.Codec
@@ -1530,6 +1620,91 @@ func TestLoad(t *testing.T) {
 
 	//----------------------------------------------------------------------
 
+	tst = test{}
+	tst.name = "defaults document"
+	tst.args.codec = toml.Codec
+	tst.args.readers = makeStringReaders([]string{
+		`
+		[sect1]
+		a1 = "sect1.a1 from file"
+		`,
+	})
+	tst.args.readerNames = nil
+	tst.args.envOverrides = nil
+	tst.env = nil
+	tst.args.defaults, err = DefaultsFromDocument(toml.Codec, []byte(`
+		A = "default A"
+		[sect1]
+		a1 = "default sect1.a1"
+		b1 = 11
+		[sect2]
+		a1 = "default sect2.a1"
+		b1 = 22
+		`))
+	if err != nil {
+		t.Fatalf("DefaultsFromDocument failed: %v", err)
+	}
+	tst.wantConfig = subStruct{
+		A: "default A",
+		S1: simpleStruct{
+			A1: "sect1.a1 from file",
+			B1: 11,
+		},
+		S2: simpleStruct{
+			A1: "default sect2.a1",
+			B1: 22,
+		},
+	}
+	tst.wantErr = false
+	tst.wantProvenances = map[string]string{
+		"A":        "[default]",
+		"sect1.A1": "[0]",
+		"sect1.B1": "[default]",
+		"sect2.A1": "[default]",
+		"sect2.B1": "[default]",
+	}
+	tst.wantIsDefineds = []Key{{"A"}, {"sect1", "b1"}, {"sect2", "a1"}}
+	tst.wantNotIsDefineds = []Key{}
+	tst.wantErrIsDefineds = []Key{}
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
+	tst = test{}
+	tst.name = "error: defaults document type mismatch"
+	tst.args.codec = toml.Codec
+	tst.args.readers = nil
+	tst.args.defaults, err = DefaultsFromDocument(toml.Codec, []byte(`
+		[sect1]
+		a1 = "default sect1.a1"
+		b1 = "should be an int"
+		`))
+	if err != nil {
+		t.Fatalf("DefaultsFromDocument failed: %v", err)
+	}
+	tst.wantConfig = subStruct{}
+	tst.wantErr = true
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
+	tst = test{}
+	tst.name = "error: defaults document vestigial field"
+	tst.args.codec = toml.Codec
+	tst.args.readers = nil
+	tst.args.defaults, err = DefaultsFromDocument(toml.Codec, []byte(`
+		[sect1]
+		nope = 1
+		`))
+	if err != nil {
+		t.Fatalf("DefaultsFromDocument failed: %v", err)
+	}
+	tst.wantConfig = subStruct{}
+	tst.wantErr = true
+	tests = append(tests, tst)
+
+	//----------------------------------------------------------------------
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			os.Clearenv()
@@ -2090,6 +2265,233 @@ func TestLoad_Special(t *testing.T) {
 	}
 }
 
+func TestDefaultsFromDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   Codec
+		doc     string
+		want    []Default
+		wantErr bool
+	}{
+		{
+			name:  "toml",
+			codec: toml.Codec,
+			doc: `
+			b = "bee"
+			a = 1
+			[c]
+			c2 = true
+			c1 = 1.5
+			[c.d]
+			[e]
+			`,
+			want: []Default{
+				{Key: Key{"a"}, Val: int64(1)},
+				{Key: Key{"b"}, Val: "bee"},
+				{Key: Key{"c", "c1"}, Val: 1.5},
+				{Key: Key{"c", "c2"}, Val: true},
+				{Key: Key{"c", "d"}, Val: map[string]interface{}{}},
+				{Key: Key{"e"}, Val: map[string]interface{}{}},
+			},
+		},
+		{
+			name:  "json",
+			codec: json.Codec,
+			doc:   `{"a": 1, "c": {"c1": [1, 2]}}`,
+			want: []Default{
+				{Key: Key{"a"}, Val: int64(1)},
+				{Key: Key{"c", "c1"}, Val: []interface{}{int64(1), int64(2)}},
+			},
+		},
+		{
+			name:    "error: bad document",
+			codec:   toml.Codec,
+			doc:     `a = = 1`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DefaultsFromDocument(tt.codec, []byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DefaultsFromDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DefaultsFromDocument() mismatch\ngot:  %#v\nwant: %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata_History(t *testing.T) {
+	type config struct {
+		Log struct {
+			Level string `toml:"level"`
+		} `toml:"log"`
+		Password string            `toml:"password" conf:"secret"`
+		Creds    map[string]string `toml:"creds" conf:"optional,secret"`
+		Port     int               `conf:"optional"`
+		Absent   string            `conf:"optional"`
+	}
+
+	readers := makeStringReaders([]string{
+		"password = \"hunter2\"\n[log]\nlevel = \"debug\"\n[creds]\nuser = \"pw\"",
+		"[log]\nlevel = \"error\"",
+	})
+	defaults := []Default{
+		{Key: Key{"Log", "Level"}, Val: "info"},
+		{Key: Key{"Password"}, Val: "default-pw"},
+	}
+
+	os.Clearenv()
+	os.Setenv("LOG_LEVEL", "warn")
+	envOverrides := []EnvOverride{{EnvVar: "LOG_LEVEL", Key: Key{"Log", "Level"}}}
+
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"config.toml", "override.toml"}, defaults, envOverrides, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	wantHistories := map[string][]ProvenanceLayer{
+		"log.level": {
+			{Src: "[default]", Kind: SourceDefault, Val: "info"},
+			{Src: "config.toml", Kind: SourceFile, Name: "config.toml", Line: 3, Column: 1, Val: "debug"},
+			{Src: "override.toml", Kind: SourceFile, Name: "override.toml", Index: 1, Line: 2, Column: 1, Val: "error"},
+			{Src: "$LOG_LEVEL", Kind: SourceEnv, Name: "LOG_LEVEL", Val: "warn"},
+		},
+		"password": {
+			{Src: "[default]", Kind: SourceDefault, Val: RedactedValue},
+			{Src: "config.toml", Kind: SourceFile, Name: "config.toml", Line: 1, Column: 1, Val: RedactedValue},
+		},
+		"creds.user": {
+			{Src: "config.toml", Kind: SourceFile, Name: "config.toml", Line: 5, Column: 1, Val: RedactedValue},
+		},
+		"Port":   nil,
+		"Absent": nil,
+	}
+	if len(md.Provenances) != len(wantHistories) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantHistories)
+	}
+	for _, prov := range md.Provenances {
+		want, ok := wantHistories[prov.Key.String()]
+		if !ok {
+			t.Fatalf("unexpected provenance: %v", prov)
+		}
+		if !reflect.DeepEqual(prov.History, want) {
+			t.Fatalf("history mismatch for %v;\ngot  %+v\nwant %+v", prov.Key, prov.History, want)
+		}
+	}
+
+	wantExplain := `log.level:
+  [default]: "info"
+  config.toml:3:1: "debug"
+  override.toml:2:1: "error"
+  $LOG_LEVEL: "warn" (final)`
+	if got := md.Explain("Log", "Level"); got != wantExplain {
+		t.Fatalf("Explain mismatch;\ngot:\n%s\nwant:\n%s", got, wantExplain)
+	}
+
+	wantExplain = `password:
+  [default]: [redacted]
+  config.toml:1:1: [redacted] (final)`
+	if got := md.Explain("password"); got != wantExplain {
+		t.Fatalf("Explain mismatch;\ngot:\n%s\nwant:\n%s", got, wantExplain)
+	}
+
+	if got, want := md.Explain("Absent"), "Absent: [absent]"; got != want {
+		t.Fatalf("Explain mismatch; got %q, want %q", got, want)
+	}
+
+	if got, want := md.Explain("nope"), "nope: no provenance (not a known field)"; got != want {
+		t.Fatalf("Explain mismatch; got %q, want %q", got, want)
+	}
+}
+
+func TestLoad_MapEntryProvenance(t *testing.T) {
+	type backend struct {
+		URL    string `toml:"url"`
+		Weight int    `toml:"weight" conf:"optional"`
+	}
+	type config struct {
+		Backends map[string]backend    `toml:"backends"`
+		Labels   map[string]interface{} `toml:"labels" conf:"optional"`
+		Hosts    []string               `toml:"hosts" conf:"optional"`
+	}
+
+	readers := makeStringReaders([]string{
+		"hosts = [\"a\"]\n[backends.us]\nurl = \"us1\"\n[backends.eu]\nurl = \"eu1\"",
+		"hosts = [\"b\", \"c\"]\n[backends.eu]\nurl = \"eu2\"\nweight = 2",
+	})
+	defaults := []Default{
+		{Key: Key{"Labels"}, Val: map[string]interface{}{"team": "core", "env": map[string]interface{}{"name": "dev"}}},
+	}
+
+	os.Clearenv()
+	os.Setenv("ENV_NAME", "prod")
+	envOverrides := []EnvOverride{{EnvVar: "ENV_NAME", Key: Key{"Labels", "env", "name"}}}
+
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"config.toml", "override.toml"}, defaults, envOverrides, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Slices are replaced as a whole, so their elements share the provenance of the slice
+	compareProvenances(t, md.Provenances, map[string]string{
+		"backends.us.url":    "config.toml",
+		"backends.eu.url":    "override.toml",
+		"backends.eu.weight": "override.toml",
+		"labels.team":        "[default]",
+		"labels.env.name":    "$ENV_NAME",
+		"hosts":              "override.toml",
+	})
+
+	wantExplain := `backends.eu.url:
+  config.toml:5:1: "eu1"
+  override.toml:3:1: "eu2" (final)`
+	if got := md.Explain("Backends", "eu", "URL"); got != wantExplain {
+		t.Fatalf("Explain mismatch;\ngot:\n%s\nwant:\n%s", got, wantExplain)
+	}
+
+	wantExplain = `labels.env.name:
+  [default]: "dev"
+  $ENV_NAME: "prod" (final)`
+	if got := md.Explain("labels", "env", "name"); got != wantExplain {
+		t.Fatalf("Explain mismatch;\ngot:\n%s\nwant:\n%s", got, wantExplain)
+	}
+}
+
+// The struct fields are shared between Loads of the same type, so what one Load learns
+// about optionality (from defaults and absent branches) must not affect the next.
+func TestLoad_OptionalNotShared(t *testing.T) {
+	type config struct {
+		A      string
+		Branch struct {
+			B string
+		} `conf:"optional"`
+	}
+
+	var result config
+	_, err := Load(toml.Codec, makeStringReaders([]string{""}), nil,
+		[]Default{{Key: Key{"A"}, Val: "default"}}, nil, &result)
+	if err != nil {
+		t.Fatalf("Load with default failed: %v", err)
+	}
+
+	result = config{}
+	_, err = Load(toml.Codec, makeStringReaders([]string{""}), nil, nil, nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "missing required fields") {
+		t.Fatalf("expected missing A without default; got %v", err)
+	}
+
+	result = config{}
+	_, err = Load(toml.Codec, makeStringReaders([]string{"A = \"a\"\n[Branch]"}), nil, nil, nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "missing required fields") {
+		t.Fatalf("expected missing Branch.B with Branch present; got %v", err)
+	}
+}
+
 func TestKey_String(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,364 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/Psiphon-Inc/configloader-go/untyped"
+	"github.com/pkg/errors"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// decodeInto assigns the values in m (the merged config map) into result, which must be
+// a pointer to a struct, and returns a map version of the whole resulting struct (for
+// Metadata.ConfigMap). Fields of result that aren't in m are left unchanged (but are
+// included in the returned map).
+//
+// This is done with reflection, instead of a round trip through codec.Marshal and
+// codec.Unmarshal, so values don't lose their types (like TOML datetimes). Struct fields
+// are matched using codec's aliases, as in GetStructFields, and fields that implement
+// encoding.TextUnmarshaler are given string values with UnmarshalText.
+func (d decoder) decodeInto(m map[string]interface{}, result interface{}) (configMap map[string]interface{}, err error) {
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.IsNil() {
+		return nil, errors.Errorf("result must be a non-nil pointer; got %T", result)
+	}
+
+	mapVal, err := d.decodeValue(m, resultVal.Elem(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, ok := mapVal.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("result must be a pointer to a struct; got %T", result)
+	}
+	return configMap, nil
+}
+
+// decodeValue assigns src into dst, and returns the config map form of the resulting
+// dst (see configValue). path is the key of dst, used for error messages.
+func (d decoder) decodeValue(src interface{}, dst reflect.Value, path Key) (interface{}, error) {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return d.configValue(dst)
+	}
+
+	srcVal := reflect.ValueOf(src)
+
+	// A value that is already of the right type (like a TOML datetime into a time.Time,
+	// or a default supplied as a Go value) is used as-is.
+	if srcVal.Type() == dst.Type() {
+		dst.Set(srcVal)
+		return d.configValue(dst)
+	}
+
+	// Strings are given to TextUnmarshalers
+	if s, ok := src.(string); ok && reflect.PtrTo(dst.Type()).Implements(textUnmarshalerType) {
+		if err := dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return nil, errors.Wrapf(err, "UnmarshalText failed for '%s'", path)
+		}
+		return d.configValue(dst)
+	}
+
+	var err error
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		if _, err = d.decodeValue(src, dst.Elem(), path); err != nil {
+			return nil, err
+		}
+
+	case reflect.Interface:
+		if !srcVal.Type().AssignableTo(dst.Type()) {
+			return nil, errors.Errorf("cannot decode %T into %s at '%s'", src, dst.Type(), path)
+		}
+		dst.Set(srcVal)
+
+	case reflect.Struct:
+		if srcVal.Kind() != reflect.Map {
+			return nil, errors.Errorf("cannot decode %T into %s at '%s'", src, dst.Type(), path)
+		}
+		return d.decodeStruct(srcVal, dst, path)
+
+	case reflect.Map:
+		if srcVal.Kind() != reflect.Map {
+			return nil, errors.Errorf("cannot decode %T into %s at '%s'", src, dst.Type(), path)
+		}
+		return d.decodeMap(srcVal, dst, path)
+
+	case reflect.Slice, reflect.Array:
+		if srcVal.Kind() != reflect.Slice && srcVal.Kind() != reflect.Array {
+			return nil, errors.Errorf("cannot decode %T into %s at '%s'", src, dst.Type(), path)
+		}
+		err = d.decodeSlice(srcVal, dst, path)
+
+	default:
+		err = d.decodeLeaf(srcVal, dst, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d.configValue(dst)
+}
+
+// decodeStruct assigns the entries of the map srcVal into the matching fields of the
+// struct dst, and returns the config map form of dst.
+func (d decoder) decodeStruct(srcVal, dst reflect.Value, path Key) (interface{}, error) {
+	// Case-folded map key -> map key
+	srcKeys := make(map[string]reflect.Value, srcVal.Len())
+	for _, k := range srcVal.MapKeys() {
+		srcKeys[foldKey(mapKeyString(k))] = k
+	}
+
+	configMap := make(map[string]interface{})
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if field.PkgPath != "" || d.codec.IsStructFieldIgnored(field.Tag) {
+			// Unexported or ignored
+			continue
+		}
+
+		name := field.Name
+		if alias := d.codec.GetStructFieldAlias(field.Tag); alias != "" {
+			name = alias
+		}
+
+		// Prefer the alias, as it's what the map will usually have
+		srcKey, found := srcKeys[foldKey(name)]
+		if !found {
+			srcKey, found = srcKeys[foldKey(field.Name)]
+		}
+
+		var fieldVal interface{}
+		var err error
+		if found {
+			fieldVal, err = d.decodeValue(srcVal.MapIndex(srcKey).Interface(), dst.Field(i), appendKey(path, name))
+		} else {
+			fieldVal, err = d.configValue(dst.Field(i))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if fieldVal != nil {
+			configMap[name] = fieldVal
+		}
+	}
+
+	return configMap, nil
+}
+
+// decodeMap assigns the entries of the map srcVal into the map dst (creating it if it's
+// nil, and otherwise adding to it), and returns the config map form of dst.
+func (d decoder) decodeMap(srcVal, dst reflect.Value, path Key) (interface{}, error) {
+	if dst.Type().Key().Kind() != reflect.String {
+		return nil, errors.Errorf("cannot decode into map with non-string keys at '%s'", path)
+	}
+
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMapWithSize(dst.Type(), srcVal.Len()))
+	}
+
+	for _, k := range srcVal.MapKeys() {
+		key := reflect.ValueOf(mapKeyString(k)).Convert(dst.Type().Key())
+
+		// Start with the existing value, so that a struct or map within the map is added to
+		// rather than replaced
+		elem := reflect.New(dst.Type().Elem()).Elem()
+		if existing := dst.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+
+		if _, err := d.decodeValue(srcVal.MapIndex(k).Interface(), elem, appendKey(path, key.String())); err != nil {
+			return nil, err
+		}
+		dst.SetMapIndex(key, elem)
+	}
+
+	return d.configValue(dst)
+}
+
+// decodeSlice replaces the contents of the slice (or array) dst with the elements of
+// srcVal.
+func (d decoder) decodeSlice(srcVal, dst reflect.Value, path Key) error {
+	if dst.Kind() == reflect.Slice {
+		dst.Set(reflect.MakeSlice(dst.Type(), srcVal.Len(), srcVal.Len()))
+	} else if srcVal.Len() > dst.Len() {
+		return errors.Errorf("too many elements for %s at '%s'; got %d", dst.Type(), path, srcVal.Len())
+	} else {
+		dst.Set(reflect.Zero(dst.Type()))
+	}
+
+	for i := 0; i < srcVal.Len(); i++ {
+		if _, err := d.decodeValue(srcVal.Index(i).Interface(), dst.Index(i), appendKey(path, strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeLeaf assigns srcVal to dst, which is a number, bool, or string. Numbers are
+// converted between types, if it can be done without loss (codecs generally produce
+// int64 and float64). Strings are converted to other types if codec is an UntypedCodec.
+func (d decoder) decodeLeaf(srcVal, dst reflect.Value, path Key) error {
+	if isNumericKind(dst.Kind()) && isNumericKind(srcVal.Kind()) {
+		if err := convertNumber(srcVal.Interface(), dst.Addr().Interface()); err != nil {
+			return errors.Wrapf(err, "bad value at '%s'", path)
+		}
+		return nil
+	}
+
+	if srcVal.Kind() == dst.Kind() && srcVal.Type().ConvertibleTo(dst.Type()) {
+		// Like a string into a named string type
+		dst.Set(srcVal.Convert(dst.Type()))
+		return nil
+	}
+
+	if _, ok := d.codec.(UntypedCodec); ok && srcVal.Kind() == reflect.String {
+		converted, err := untyped.ConvertToType(srcVal.String(), dst.Type())
+		if err != nil {
+			return errors.Wrapf(err, "bad value at '%s'", path)
+		}
+		dst.Set(converted)
+		return nil
+	}
+
+	return errors.Errorf("cannot decode %s into %s at '%s'", srcVal.Type(), dst.Type(), path)
+}
+
+// configValue returns the form of v used in Metadata.ConfigMap: structs and maps become
+// map[string]interface{} (keyed by codec's aliases), slices and arrays become
+// []interface{}, and numbers, strings, and bools have the types that codecs generally
+// decode them to (see normalizeValue). Values that implement encoding.TextMarshaler (like time.Time)
+// are left as they are. Nil values are nil, and are omitted from the containing map.
+func (d decoder) configValue(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+	case reflect.Invalid:
+		return nil, nil
+	}
+
+	if v.Type().Implements(textMarshalerType) || reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		return v.Interface(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return d.configValue(v.Elem())
+
+	case reflect.Struct:
+		configMap := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || d.codec.IsStructFieldIgnored(field.Tag) {
+				continue
+			}
+
+			name := field.Name
+			if alias := d.codec.GetStructFieldAlias(field.Tag); alias != "" {
+				name = alias
+			}
+
+			fieldVal, err := d.configValue(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			if fieldVal != nil {
+				configMap[name] = fieldVal
+			}
+		}
+		return configMap, nil
+
+	case reflect.Map:
+		configMap := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			elemVal, err := d.configValue(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			if elemVal != nil {
+				configMap[mapKeyString(k)] = elemVal
+			}
+		}
+		return configMap, nil
+
+	case reflect.Slice, reflect.Array:
+		slice := make([]interface{}, v.Len())
+		for i := range slice {
+			elemVal, err := d.configValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = elemVal
+		}
+		return slice, nil
+	}
+
+	return normalizeValue(v), nil
+}
+
+// normalizeValue returns the number, string, or bool v with the type that the TOML and
+// JSON codecs decode such values to, so that ConfigMap has the same types whatever the
+// types of the result's fields: int64 for integers (or uint64 for unsigned values too
+// big for int64), float64 for floats, and string and bool for named string and bool
+// types. Named integer types like time.Duration become int64 too. Other values are
+// returned as-is.
+func normalizeValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u > math.MaxInt64 {
+			return u
+		}
+		return int64(v.Uint())
+	case reflect.Float32:
+		// Use the shortest decimal form of the float32 (like 0.1, not 0.10000000149011612),
+		// which is what the codecs would have decoded
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(v.Float(), 'g', -1, 32), 64)
+		return f
+	case reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	}
+	return v.Interface()
+}
+
+// mapKeyString returns the map key k as a string. Keys that aren't strings (which some
+// codecs, like YAML, can produce) are formatted.
+func mapKeyString(k reflect.Value) string {
+	if k.Kind() == reflect.Interface {
+		k = k.Elem()
+	}
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	return fmt.Sprint(k.Interface())
+}
+
+// appendKey returns a new key that is k with elem appended (without modifying k).
+func appendKey(k Key, elem string) Key {
+	return append(append(Key{}, k...), elem)
+}
@@ -0,0 +1,168 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+	"github.com/pkg/errors"
+)
+
+type decodeLevel int
+
+func (l *decodeLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return errors.Errorf("bad level: %s", text)
+	}
+	return nil
+}
+
+type decodeConfig struct {
+	Name    string                 `toml:"name"`
+	Level   decodeLevel            `toml:"level"`
+	Start   time.Time              `toml:"start"`
+	Port    uint16                 `toml:"port"`
+	Ratio   float32                `toml:"ratio"`
+	Timeout time.Duration          `toml:"timeout"`
+	Tags    []string               `toml:"tags"`
+	Extra   map[string]interface{} `toml:"extra"`
+	Kept    string                 `toml:"kept" conf:"optional"`
+	Nested  decodeNested           `toml:"nested"`
+	Ignored string                 `toml:"-"`
+}
+
+type decodeNested struct {
+	Hosts map[string]int `toml:"hosts"`
+}
+
+func TestLoad_Decode(t *testing.T) {
+	start := time.Date(2019, 5, 27, 7, 32, 0, 0, time.UTC)
+
+	var result decodeConfig
+	result.Kept = "pre-populated"
+	result.Ignored = "untouched"
+
+	md, err := Load(toml.Codec, makeStringReaders([]string{`
+name = "decode"
+level = "high"
+start = 2019-05-27T07:32:00Z
+port = 443
+ratio = 0.1
+timeout = 5_000_000_000
+tags = ["a", "b"]
+[extra]
+when = 2019-05-27T07:32:00Z
+count = 3
+[nested.hosts]
+one = 1
+`}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := decodeConfig{
+		Name:    "decode",
+		Level:   2,
+		Start:   start,
+		Port:    443,
+		Ratio:   0.1,
+		Timeout: 5 * time.Second,
+		Tags:    []string{"a", "b"},
+		Extra:   map[string]interface{}{"when": start, "count": int64(3)},
+		Kept:    "pre-populated",
+		Nested:  decodeNested{Hosts: map[string]int{"one": 1}},
+		Ignored: "untouched",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	// ConfigMap has the types that the codec decodes to, not those of the fields
+	wantConfigMap := map[string]interface{}{
+		"name":    "decode",
+		"level":   int64(2),
+		"start":   start,
+		"port":    int64(443),
+		"ratio":   float64(0.1),
+		"timeout": int64(5 * time.Second),
+		"tags":    []interface{}{"a", "b"},
+		"extra":   map[string]interface{}{"when": start, "count": int64(3)},
+		"kept":    "pre-populated",
+		"nested":  map[string]interface{}{"hosts": map[string]interface{}{"one": int64(1)}},
+	}
+	if !reflect.DeepEqual(md.ConfigMap, wantConfigMap) {
+		t.Fatalf("ConfigMap mismatch;\ngot  %#v\nwant %#v", md.ConfigMap, wantConfigMap)
+	}
+}
+
+func TestLoad_DecodeIntoExisting(t *testing.T) {
+	type config struct {
+		Hosts map[string]int
+		List  []int
+	}
+
+	result := config{
+		Hosts: map[string]int{"existing": 1},
+		List:  []int{9, 9, 9},
+	}
+
+	_, err := Load(json.Codec, makeStringReaders([]string{`{"Hosts": {"new": 2}, "List": [1]}`}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Maps are added to; slices are replaced
+	want := config{
+		Hosts: map[string]int{"existing": 1, "new": 2},
+		List:  []int{1},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch; got %+v, want %+v", result, want)
+	}
+}
+
+func TestLoad_DecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "bad text",
+			config:  `level = "medium"`,
+			wantErr: "UnmarshalText failed for 'level'",
+		},
+		{
+			name:    "integer float",
+			config:  `port = 1.5`,
+			wantErr: "port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result struct {
+				Level decodeLevel `toml:"level" conf:"optional"`
+				Port  uint16      `toml:"port" conf:"optional"`
+			}
+			_, err := Load(toml.Codec, makeStringReaders([]string{tt.config}), nil, nil, nil, &result)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
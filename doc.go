@@ -5,13 +5,23 @@
  */
 
 /*
-Package configloader makes loading config information easier, more flexible, and more powerful. It enables loading from multiple files, defaults, and environment overrides. TOML and JSON are supported out-of-the-box, but other formats can be easily used.
+Package configloader makes loading config information easier, more flexible, and more powerful. It enables loading from multiple files, defaults, and environment overrides. TOML, JSON (with or without comments), YAML, HCL, INI, Java .properties, and .env are supported out-of-the-box (each in its own sub-package, so you only pull in the dependencies you use), but other formats can be easily used.
+
+LoadInto() is a generic alternative to Load() that returns the populated struct (or map) rather than populating a pointer, with the other arguments supplied as LoadOptions (like WithCodec and WithFiles).
 
 It is recommended that the examples be perused to assist usage: https://github.com/Psiphon-Inc/configloader-go/tree/master/examples
 
 Result Structs and Maps
 
-The value to be populated can be a struct or a map[string]interface{}. A struct is preferable, as it provides information about what fields should be expected, which are optional, and so on.
+The value to be populated can be a struct or a map[string]interface{}. A struct is preferable, as it provides information about what fields should be expected, which are optional, and so on. When using a map, the typed accessors on Metadata (Get, GetString, GetInt, GetBool, GetDuration, GetStringSlice, and the generic GetAs) avoid type assertions on the map values.
+
+Mixing Config File Formats
+
+Config files of different formats can be used together. Register codecs by filename extension with RegisterCodec() and FindFiles() will pick the codec for each file; or wrap a reader with ReaderWithCodec(). The codec passed to Load() is used for the result struct, and values from other formats are reconciled with its struct tag aliases.
+
+Flat formats, like .env files, map keys such as SERVER_LISTENPORT onto nested fields such as Server.ListenPort (see FlatCodec). Codecs that implement PositionCodec (including the TOML and JSON codecs) also provide the line and column of each value in its Provenance.
+
+Problems with the value a source supplies for a field (vestigial fields, type mismatches, numbers out of range) are returned as a *FieldError, which includes the key, the source, and the line and column, when known.
 
 Struct Field Tags
 
@@ -29,6 +39,8 @@ Default values for otherwise absent fields can be passed to Load(). Default valu
 
 Fields with defaults provided in this manner are implicitly considered optional fields.
 
+Defaults can also be supplied as a complete config document (for example, a TOML file embedded with go:embed) by converting it with DefaultsFromDocument(). The resulting defaults are treated exactly like those written in code.
+
 If a default value depends on the the values of other fields, then it should be flagged as optional via the struct tag, loaded from config, then checked with metadata.IsDefined() to see if it was set (in a file or environment override), and populated appropriately if it wasn't.
 
 It is possible but not recommended to provide defaults by pre-populating the struct or map result. It's also possible but not recommended to check metadata.IsDefined() in accessors and return a default if not defined. Both of these approaches will result in the provenance being "[absent]" rather than "[default]".
@@ -37,8 +49,26 @@ Specify Field Type
 
 The type to used for comparison can be specified with a struct tag, like `conf:",float32"` (before the comma is "optional", or not). It will be compared against the Type and Kind of the field. (There may not be any good use for this. If we come across one, add it here. Otherwise re-think the existence of this feature. See issue: https://github.com/Psiphon-Inc/configloader-go/issues/1)
 
+Secret Fields
+
+A field can be flagged as secret with `conf:"secret"` (which may be combined with the other options, like `conf:"optional,secret"`). Fields within a secret struct or map are also secret. The values of secret fields are redacted from Provenance.History and Metadata.Explain(). (Note that Metadata.ConfigMap is not redacted.)
+
+Provenance History
+
+Each Provenance records the winning source in Src, and the full chain of sources that supplied a value in History -- for example, a default, then config.toml, then config_override.toml, then an environment variable. Metadata.Explain() formats the history of a field for humans. Entries of a map within a struct each get their own provenance, whether they came from files, defaults, or environment variables (so a default map and a file can each supply some of the entries). Slices are replaced as a whole by each source, so the elements of a slice share its provenance. The Kind, Name, and Index fields of Provenance provide the same information as Src without string parsing. The values in History are left out when Provenances are serialized with encoding/json (for example, as a field of a JSON log entry), as some config values may be sensitive without being flagged secret; use Metadata.Explain() to see them. (slog output is different; see below.)
+
+Logging
+
+Metadata, Provenances, and Key implement slog.LogValuer, so they can be passed directly to a log/slog logger and will be logged as structured groups. Unlike with encoding/json, this includes config values: Metadata is logged with its config, and each Provenance with its final value. Only the values of secret fields are redacted, so fields whose values shouldn't be logged must be flagged secret. Set the Logger variable (before any concurrent loads), or pass WithLogger to LoadInto(), to receive warnings from Load() about config that probably doesn't do what was intended.
+
+Schema Introspection
+
+Describe() returns a tree of the fields of a config struct, with their keys, aliases, Go types, whether they are optional, their defaults and env overrides (from the same LoadOptions as LoadInto), whether they are secret, and documentation text from struct tags like `doc:"The port to listen on"`. It is intended for building tools like documentation generators and validators.
+
+JSONSchema() uses it to generate a JSON Schema (draft 2020-12) for config files, for validating them in editors and CI. The schema also includes enums and ranges from validation rules in struct tags like `validate:"min=1,max=65535"` (which configloader itself doesn't enforce).
+
 Support for TextUnmarshaler
 
-configloader detects fields that implement encoding/TextUnmarshaler and expects to find string values for those fields. This means that support for TextUnmarshaler is expected from the underlying unmarshaler.
+configloader detects fields that implement encoding/TextUnmarshaler and expects to find string values for those fields, which it passes to UnmarshalText. (A value that the codec has already decoded to the field's type, like a TOML datetime for a time.Time field, is used as-is.)
 */
 package configloader
@@ -0,0 +1,295 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package dotenv provides .env file Codec methods for use with configloader.
+//
+// The supported syntax is:
+//  # comment
+//  KEY=value
+//  export KEY=value
+//  UNQUOTED=value with spaces # trailing comment
+//  SINGLE='literal value, no escapes'
+//  DOUBLE="value with escapes\tand
+//  newlines"
+// Later values for the same key replace earlier ones.
+//
+// .env keys are flat. When loading into a struct, a key is matched against the field
+// names or aliases along the key path joined with underscores, case-insensitively (see
+// configloader.FlatCodec). So SERVER_LISTENPORT sets Server.ListenPort, and SERVER_PORT
+// would set it if the field had the tag `env:"port"`. If the key path leads into a map
+// field, the remainder of the key is used as the map key.
+//
+// .env values are untyped strings. They are converted to the types of the corresponding
+// result struct fields (see configloader.UntypedCodec and the untyped package). Slice
+// values are comma-separated.
+//
+// To use .env files with FindFiles, register the codec for the extension:
+//  configloader.RegisterCodec(".env", dotenv.Codec)
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/untyped"
+	"github.com/pkg/errors"
+)
+
+// KeySeparator joins the elements of flat keys.
+const KeySeparator = "_"
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation. It also implements
+// configloader.UntypedCodec, configloader.FlatCodec, and configloader.PositionCodec.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	m, err := untyped.ToMap(v, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	flat := untyped.FlattenMap(m, KeySeparator)
+
+	var buf bytes.Buffer
+	for _, k := range untyped.SortedKeys(flat) {
+		if !validKey(k) {
+			return nil, errors.Errorf("invalid .env key: %q", k)
+		}
+
+		s, ok := flat[k].(string)
+		if !ok {
+			// An empty map; there's nothing to write
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", k, quoteIfNeeded(s))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// quoteIfNeeded quotes s if it wouldn't otherwise survive a round trip.
+func quoteIfNeeded(s string) string {
+	if s != strings.TrimSpace(s) || strings.ContainsAny(s, "\"'\\\n\r\t#") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	entries, err := parse(data)
+	if err != nil {
+		return err
+	}
+
+	flat := make(map[string]interface{})
+	for _, e := range entries {
+		flat[e.key] = e.val
+	}
+
+	if m, ok := v.(*map[string]interface{}); ok {
+		if *m == nil {
+			*m = make(map[string]interface{})
+		}
+		for k, val := range flat {
+			(*m)[k] = val
+		}
+		return nil
+	}
+
+	nested, err := untyped.NestFlatKeys(flat, KeySeparator, v, codec)
+	if err != nil {
+		return err
+	}
+
+	return untyped.Decode(nested, v, codec)
+}
+
+// entry is a single KEY=value from a .env file.
+type entry struct {
+	key          string
+	val          string
+	line, column int
+}
+
+// parse parses .env data into its entries, in order.
+func parse(data []byte) ([]entry, error) {
+	var entries []entry
+
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimLeft(lines[i], " \t")
+		column := len(lines[i]) - len(line) + 1
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			trimmed := strings.TrimLeft(line[len("export"):], " \t")
+			column += len(line) - len(trimmed)
+			line = trimmed
+		}
+
+		sep := strings.Index(line, "=")
+		if sep < 0 {
+			return nil, errors.Errorf("line %d: expected KEY=value: %s", lineNum, line)
+		}
+
+		key := strings.TrimSpace(line[:sep])
+		if !validKey(key) {
+			return nil, errors.Errorf("line %d: invalid key: %q", lineNum, key)
+		}
+
+		val := strings.TrimLeft(line[sep+1:], " \t")
+		switch {
+		case strings.HasPrefix(val, `"`) || strings.HasPrefix(val, "'"):
+			// Quoted values may continue onto following lines
+			quote := val[0]
+			raw := val[1:]
+			end := closingQuote(raw, quote)
+			for end < 0 && i+1 < len(lines) {
+				i++
+				raw += "\n" + lines[i]
+				end = closingQuote(raw, quote)
+			}
+			if end < 0 {
+				return nil, errors.Errorf("line %d: unterminated quoted value for %s", lineNum, key)
+			}
+
+			rest := strings.TrimSpace(raw[end+1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, errors.Errorf("line %d: unexpected text after quoted value for %s: %s", lineNum, key, rest)
+			}
+
+			val = raw[:end]
+			if quote == '"' {
+				var err error
+				if val, err = unescape(val); err != nil {
+					return nil, errors.Wrapf(err, "line %d: bad quoted value for %s", lineNum, key)
+				}
+			}
+
+		default:
+			// Unquoted values may have a trailing comment, which must follow whitespace
+			if idx := strings.Index(val, " #"); idx >= 0 {
+				val = val[:idx]
+			}
+			if idx := strings.Index(val, "\t#"); idx >= 0 {
+				val = val[:idx]
+			}
+			val = strings.TrimSpace(val)
+		}
+
+		entries = append(entries, entry{key: key, val: val, line: lineNum, column: column})
+	}
+
+	return entries, nil
+}
+
+// closingQuote returns the index of the quote that closes s, or -1 if there isn't one.
+// Double-quoted values may contain escaped quotes.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+// unescape processes the backslash escapes in a double-quoted value.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	// strconv.Unquote does the work, but it doesn't allow literal newlines
+	unquoted, err := strconv.Unquote(`"` + strings.Replace(s, "\n", `\n`, -1) + `"`)
+	if err != nil {
+		return "", err
+	}
+	return unquoted, nil
+}
+
+// validKey returns true if k can be used as a .env key.
+func validKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for _, r := range k {
+		if !(r == '_' || r == '.' || r == '-' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("env") == "-"
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	if codec.IsStructFieldIgnored(st) {
+		return ""
+	}
+
+	if typeTag := st.Get("env"); typeTag != "" {
+		return strings.Split(typeTag, ",")[0]
+	}
+
+	return ""
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	return untyped.FieldTypesConsistent(check, gold)
+}
+
+// ConvertString implements configloader.UntypedCodec.
+func (codec codecImplmentation) ConvertString(s string, field *reflection.StructField) (interface{}, error) {
+	return untyped.ConvertString(s, field)
+}
+
+// KeySeparator implements configloader.FlatCodec.
+func (codec codecImplmentation) KeySeparator() string {
+	return KeySeparator
+}
+
+// KeyPositions implements configloader.PositionCodec. A key that is set more than once
+// has the position of its last occurrence.
+func (codec codecImplmentation) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	entries, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []reflection.KeyPosition
+	indexes := make(map[string]int)
+	for _, e := range entries {
+		pos := reflection.KeyPosition{Key: []string{e.key}, Line: e.line, Column: e.column}
+		if i, ok := indexes[e.key]; ok {
+			positions[i] = pos
+			continue
+		}
+		indexes[e.key] = len(positions)
+		positions = append(positions, pos)
+	}
+
+	return positions, nil
+}
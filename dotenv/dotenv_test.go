@@ -0,0 +1,233 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package dotenv_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/dotenv"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+type config struct {
+	Name   string
+	Server struct {
+		ListenPort uint16
+		Hostname   string        `env:"host"`
+		Timeout    time.Duration `conf:"optional"`
+		Debug      bool          `env:"debug_mode" conf:"optional"`
+	}
+	Tags     []string       `conf:"optional"`
+	Labels   map[string]int `conf:"optional"`
+	Backends map[string]struct {
+		URL    string
+		Weight uint8 `conf:"optional"`
+	} `conf:"optional"`
+	Ignored string `env:"-" conf:"optional"`
+}
+
+func TestLoad(t *testing.T) {
+	readers := []io.Reader{
+		strings.NewReader(`
+# The main settings
+NAME="  spaced \"name\"  "
+SERVER_LISTENPORT=80
+  export SERVER_HOST=example.com # a comment
+server_timeout='5s'
+TAGS=a, b
+LABELS_a=1
+BACKENDS_ap_east_URL=https://ap.example.com
+`),
+		strings.NewReader(`SERVER_LISTENPORT=8080
+SERVER_DEBUG_MODE=true
+LABELS_b=2
+BACKENDS_ap_east_WEIGHT=3
+`),
+	}
+
+	var result config
+	md, err := configloader.Load(dotenv.Codec, readers, []string{"base.env", "local.env"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Name = `  spaced "name"  `
+	want.Server.ListenPort = 8080
+	want.Server.Hostname = "example.com"
+	want.Server.Timeout = 5 * time.Second
+	want.Server.Debug = true
+	want.Tags = []string{"a", "b"}
+	want.Labels = map[string]int{"a": 1, "b": 2}
+	want.Backends = map[string]struct {
+		URL    string
+		Weight uint8 `conf:"optional"`
+	}{"ap_east": {URL: "https://ap.example.com", Weight: 3}}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	type position struct {
+		src          string
+		line, column int
+	}
+	wantProvs := map[string]position{
+		"Name":                    {"base.env", 3, 1},
+		"Server.ListenPort":       {"local.env", 1, 1},
+		"Server.host":             {"base.env", 5, 10},
+		"Server.Timeout":          {"base.env", 6, 1},
+		"Server.debug_mode":       {"local.env", 2, 1},
+		"Tags":                    {"base.env", 7, 1},
+		"Labels.a":                {"base.env", 8, 1},
+		"Labels.b":                {"local.env", 3, 1},
+		"Backends.ap_east.URL":    {"base.env", 9, 1},
+		"Backends.ap_east.Weight": {"local.env", 4, 1},
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		got := position{prov.Src, prov.Line, prov.Column}
+		if wantProvs[prov.Key.String()] != got {
+			t.Fatalf("provenance mismatch for %v; got %+v, want %+v", prov.Key, got, wantProvs[prov.Key.String()])
+		}
+	}
+}
+
+func TestLoad_WithTOML(t *testing.T) {
+	type mixedConfig struct {
+		Server struct {
+			ListenPort int    `toml:"listen_port"`
+			Hostname   string `toml:"hostname"`
+		} `toml:"server"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader("[server]\nlisten_port = 80\nhostname = \"example.com\"\n"),
+		configloader.ReaderWithCodec(strings.NewReader("\n\nSERVER_LISTENPORT=8080\n"), dotenv.Codec),
+	}
+
+	var result mixedConfig
+	md, err := configloader.Load(toml.Codec, readers, []string{"config.toml", ".env"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if result.Server.ListenPort != 8080 || result.Server.Hostname != "example.com" {
+		t.Fatalf("result mismatch: %+v", result)
+	}
+
+	for _, prov := range md.Provenances {
+		if prov.Key.String() == "server.listen_port" {
+			if prov.Src != ".env" || prov.Line != 3 || prov.Column != 1 {
+				t.Fatalf("bad provenance: %+v", prov)
+			}
+		} else if prov.Src != "config.toml" || prov.Line != 3 || prov.Column != 1 {
+			t.Fatalf("bad provenance: %+v", prov)
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"vestigial key", "NAME=a\nSERVER_LISTENPORT=1\nSERVER_HOST=h\nSERVER_NOPE=1\n"},
+		{"vestigial map value key", "NAME=a\nSERVER_LISTENPORT=1\nSERVER_HOST=h\nBACKENDS_ap_PORT=1\n"},
+		{"ignored field", "NAME=a\nSERVER_LISTENPORT=1\nSERVER_HOST=h\nIGNORED=x\n"},
+		{"type mismatch", "NAME=a\nSERVER_LISTENPORT=abc\nSERVER_HOST=h\n"},
+		{"missing required", "NAME=a\n"},
+		{"no separator", "NAME\n"},
+		{"bad key", "NA ME=a\n"},
+		{"unterminated quote", "NAME=\"abc\n"},
+		{"text after quote", "NAME='abc' def\n"},
+		{"bad escape", `NAME="\q"` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := configloader.Load(dotenv.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, nil, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	doc := "A=1\r\nexport B = ' two '\nC=\"multi\nline\\t\" # comment\nD=\nA=again\n"
+
+	var got map[string]interface{}
+	if err := dotenv.Codec.Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"A": "again",
+		"B": " two ",
+		"C": "multi\nline\t",
+		"D": "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unmarshal mismatch;\ngot  %#v\nwant %#v", got, want)
+	}
+
+	positions, err := dotenv.Codec.KeyPositions([]byte(doc))
+	if err != nil {
+		t.Fatalf("KeyPositions failed: %v", err)
+	}
+	wantLines := map[string]int{"A": 6, "B": 2, "C": 3, "D": 5}
+	if len(positions) != len(wantLines) {
+		t.Fatalf("KeyPositions mismatch: %+v", positions)
+	}
+	for _, pos := range positions {
+		if pos.Line != wantLines[pos.Key[0]] {
+			t.Fatalf("KeyPositions mismatch for %s: %+v", pos.Key[0], pos)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	m := map[string]interface{}{
+		"B": "bee",
+		"A": int64(1),
+		"S": map[string]interface{}{
+			"X":   []interface{}{int64(1), int64(2)},
+			"SUB": map[string]interface{}{"Y": " padded # not a comment"},
+		},
+	}
+
+	got, err := dotenv.Codec.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := "A=1\nB=bee\nS_SUB_Y=\" padded # not a comment\"\nS_X=1, 2\n"
+	if string(got) != want {
+		t.Fatalf("Marshal mismatch;\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	var roundTrip map[string]interface{}
+	if err := dotenv.Codec.Unmarshal(got, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	wantRoundTrip := map[string]interface{}{
+		"A":       "1",
+		"B":       "bee",
+		"S_SUB_Y": " padded # not a comment",
+		"S_X":     "1, 2",
+	}
+	if !reflect.DeepEqual(roundTrip, wantRoundTrip) {
+		t.Fatalf("round trip mismatch;\ngot  %#v\nwant %#v", roundTrip, wantRoundTrip)
+	}
+}
@@ -49,5 +49,5 @@ func main() {
 	fmt.Printf("Config: %+v\n", config)
 	fmt.Printf("Provenances: %+v\n", metadata.Provenances)
 
-	// Then start on our server, listening on port config["listen_port"].(int)
+	// Then start on our server, listening on port metadata.GetInt("listen_port")
 }
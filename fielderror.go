@@ -0,0 +1,97 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+)
+
+// FieldError is the error returned by Load() when a source supplies a bad value for a
+// field: a vestigial field (one not in the result struct), a value of the wrong type, or
+// a number that's out of range for its field. It is returned as-is (not wrapped), so it
+// can be checked for with a type assertion.
+type FieldError struct {
+	// The key of the field, as it was given by the source (so it may use aliases).
+	Key Key
+
+	// The source of the value, in the same form as Provenance.Src.
+	Src string
+
+	// The 1-based line and column of the field within Src. They are only set if Src is
+	// a file whose codec implements PositionCodec; otherwise they are zero.
+	Line, Column int
+
+	// The underlying problem.
+	Err error
+}
+
+// Error implements the error interface. It looks like:
+//  config.toml:12:3: key 'server.port': value 70000 out of range for uint16
+func (e *FieldError) Error() string {
+	var sb strings.Builder
+	if e.Src != "" {
+		sb.WriteString(e.Src)
+		if e.Line > 0 {
+			fmt.Fprintf(&sb, ":%d:%d", e.Line, e.Column)
+		}
+		sb.WriteString(": ")
+	}
+	fmt.Fprintf(&sb, "key '%s': %v", e.Key, e.Err)
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// withSource fills in the source information of err, if it is a *FieldError. It returns
+// whether it was.
+func withSource(err error, src string, positions []reflection.KeyPosition, posFields []*reflection.StructField) bool {
+	fe, ok := err.(*FieldError)
+	if !ok {
+		return false
+	}
+
+	fe.Src = src
+	if pos, found := findPosition(fe.Key, positions, posFields); found {
+		fe.Line, fe.Column = pos.Line, pos.Column
+	}
+	return true
+}
+
+// findPosition finds the position of k among positions. The keys are compared using the
+// aliases in structFields. If there's no position for k itself, the position of its
+// closest ancestor is used (so that, for example, a field within a TOML inline table or
+// a JSON array gets the position of the table or array).
+func findPosition(k Key, positions []reflection.KeyPosition, structFields []*reflection.StructField) (pos reflection.KeyPosition, found bool) {
+	return newPositionIndex(positions, newFieldIndex(structFields)).find(k)
+}
+
+// envVarForKey returns the provenance source string ("$ENV_VAR_NAME") for the env
+// override that set key k, or "" if it can't be determined.
+func envVarForKey(k Key, envOverrides []EnvOverride, structFields []*reflection.StructField) string {
+	ak := aliasedKeyFromKey(k)
+	if sf, exact := findStructField(structFields, ak); exact {
+		ak = sf.AliasedKey
+	}
+
+	for _, eo := range envOverrides {
+		eoKey := aliasedKeyFromKey(eo.Key)
+		if sf, exact := findStructField(structFields, eoKey); exact {
+			eoKey = sf.AliasedKey
+		}
+		if ak.Equal(eoKey) {
+			return "$" + eo.EnvVar
+		}
+	}
+
+	return ""
+}
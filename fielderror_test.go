@@ -0,0 +1,229 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+type positionsConfig struct {
+	Name   string `toml:"name" json:"name"`
+	Server struct {
+		ListenPort uint16 `toml:"listen_port" json:"listen_port"`
+		Hosts      []string
+		Note       string `conf:"optional"`
+	} `toml:"server" json:"server"`
+	Inline struct {
+		A int
+	} `conf:"optional"`
+	Labels map[string]string `conf:"optional"`
+}
+
+func TestKeyPositions(t *testing.T) {
+	tomlDoc := `# comment
+name = "a # not a comment"
+
+[server]
+  listen_port = 80 # comment
+Hosts = [
+  "a",
+  "b",
+]
+"Note" = """
+multi-line [
+"""
+
+[Inline]
+A = 1
+
+[[arr]]
+x = 1
+
+[Labels]
+'quoted.key' = "v"
+`
+
+	got, err := toml.Codec.KeyPositions([]byte(tomlDoc))
+	if err != nil {
+		t.Fatalf("toml KeyPositions failed: %v", err)
+	}
+	want := []reflection.KeyPosition{
+		{Key: []string{"name"}, Line: 2, Column: 1},
+		{Key: []string{"server"}, Line: 4, Column: 1},
+		{Key: []string{"server", "listen_port"}, Line: 5, Column: 3},
+		{Key: []string{"server", "Hosts"}, Line: 6, Column: 1},
+		{Key: []string{"server", "Note"}, Line: 10, Column: 1},
+		{Key: []string{"Inline"}, Line: 14, Column: 1},
+		{Key: []string{"Inline", "A"}, Line: 15, Column: 1},
+		{Key: []string{"Labels"}, Line: 20, Column: 1},
+		{Key: []string{"Labels", "quoted.key"}, Line: 21, Column: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("toml KeyPositions mismatch;\ngot  %+v\nwant %+v", got, want)
+	}
+
+	jsonDoc := `{
+  "name": "a",
+  "server": {"listen_port": 80,
+    "Hosts": [{"x": 1}]}
+}`
+
+	got, err = json.Codec.KeyPositions([]byte(jsonDoc))
+	if err != nil {
+		t.Fatalf("json KeyPositions failed: %v", err)
+	}
+	want = []reflection.KeyPosition{
+		{Key: []string{"name"}, Line: 2, Column: 3},
+		{Key: []string{"server"}, Line: 3, Column: 3},
+		{Key: []string{"server", "listen_port"}, Line: 3, Column: 14},
+		{Key: []string{"server", "Hosts"}, Line: 4, Column: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("json KeyPositions mismatch;\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLoad_Positions(t *testing.T) {
+	readers := []io.Reader{
+		strings.NewReader(`
+name = "a"
+Inline = { A = 1 }
+
+[server]
+listen_port = 80
+Hosts = ["a"]
+`),
+		ReaderWithCodec(strings.NewReader(`{
+  "server": {
+    "listen_port": 8080
+  },
+  "Labels": {"x": "y"}
+}`), json.Codec),
+	}
+
+	os.Clearenv()
+	os.Setenv("NOTE", "from env")
+	envOverrides := []EnvOverride{{EnvVar: "NOTE", Key: Key{"Server", "Note"}}}
+
+	var result positionsConfig
+	md, err := Load(toml.Codec, readers, []string{"config.toml", "override.json"}, nil, envOverrides, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	type position struct {
+		src          string
+		line, column int
+	}
+	wantProvs := map[string]position{
+		"name":               {"config.toml", 2, 1},
+		"Inline.A":           {"config.toml", 3, 1}, // position of the inline table
+		"server.listen_port": {"override.json", 3, 5},
+		"server.Hosts":       {"config.toml", 7, 1},
+		"server.Note":        {"$NOTE", 0, 0},
+		"Labels.x":           {"override.json", 5, 14},
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		got := position{prov.Src, prov.Line, prov.Column}
+		if wantProvs[prov.Key.String()] != got {
+			t.Fatalf("provenance mismatch for %v; got %+v, want %+v", prov.Key, got, wantProvs[prov.Key.String()])
+		}
+	}
+}
+
+func TestLoad_FieldError(t *testing.T) {
+	tests := []struct {
+		name         string
+		codec        Codec
+		doc          string
+		defaults     []Default
+		env          map[string]string
+		envOverrides []EnvOverride
+		want         FieldError
+	}{
+		{
+			name:  "vestigial toml",
+			codec: toml.Codec,
+			doc:   "name = \"a\"\n\n[server]\n  nope = 1\n",
+			want:  FieldError{Key: Key{"server", "nope"}, Src: "the_reader", Line: 4, Column: 3},
+		},
+		{
+			name:  "type mismatch json",
+			codec: json.Codec,
+			doc:   "{\"name\": \"a\",\n \"server\": {\"listen_port\": \"eighty\"}}",
+			want:  FieldError{Key: Key{"server", "listen_port"}, Src: "the_reader", Line: 2, Column: 13},
+		},
+		{
+			name:  "out of range toml",
+			codec: toml.Codec,
+			doc:   "name = \"a\"\n[server]\nlisten_port = 70000\n",
+			want:  FieldError{Key: Key{"server", "listen_port"}, Src: "the_reader", Line: 3, Column: 1},
+		},
+		{
+			name:     "bad default",
+			codec:    toml.Codec,
+			doc:      "",
+			defaults: []Default{{Key: Key{"Server", "ListenPort"}, Val: -1}},
+			want:     FieldError{Key: Key{"server", "listen_port"}, Src: "[default]"},
+		},
+		{
+			name:         "bad env override",
+			codec:        toml.Codec,
+			doc:          "",
+			env:          map[string]string{"PORT": "70000"},
+			envOverrides: []EnvOverride{{EnvVar: "PORT", Key: Key{"Server", "ListenPort"}, Conv: func(s string) (interface{}, error) { return 70000, nil }}},
+			want:         FieldError{Key: Key{"server", "listen_port"}, Src: "$PORT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			var result positionsConfig
+			_, err := Load(tt.codec, makeStringReaders([]string{tt.doc}), []string{"the_reader"}, tt.defaults, tt.envOverrides, &result)
+			fe, ok := err.(*FieldError)
+			if !ok {
+				t.Fatalf("expected *FieldError; got %T: %v", err, err)
+			}
+
+			if fe.Err == nil || fe.Unwrap() != fe.Err {
+				t.Fatalf("bad underlying error: %v", fe.Err)
+			}
+			got := *fe
+			got.Err = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FieldError mismatch;\ngot  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldError_Error(t *testing.T) {
+	fe := &FieldError{Key: Key{"a", "b"}, Src: "config.toml", Line: 12, Column: 3, Err: os.ErrInvalid}
+	if got, want := fe.Error(), "config.toml:12:3: key 'a.b': invalid argument"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	fe = &FieldError{Key: Key{"a"}, Src: "$ENV", Err: os.ErrInvalid}
+	if got, want := fe.Error(), "$ENV: key 'a': invalid argument"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
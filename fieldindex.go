@@ -0,0 +1,327 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+)
+
+// fieldIndex indexes struct fields by key, so that a field can be found without scanning
+// all of the fields. It is a tree with a node for each key element; each node's children
+// are keyed by every alias of the child's key element, case-folded (so the matching is
+// the same as with AliasedKey.Equal).
+// A nil *fieldIndex is valid and empty.
+type fieldIndex struct {
+	// The field at this node's key. May be nil if the fields that were indexed didn't
+	// include this node's key (only its descendants).
+	field *reflection.StructField
+
+	// The case-folded form of the first alias of this node's key element. Used to build
+	// canonical keys.
+	name string
+
+	children map[string]*fieldIndex
+}
+
+// newFieldIndex creates an index of fields. If more than one field has the same key, the
+// first is used (as with a scan of fields).
+func newFieldIndex(fields []*reflection.StructField) *fieldIndex {
+	idx := &fieldIndex{}
+	for _, sf := range fields {
+		idx.add(sf)
+	}
+	return idx
+}
+
+// add adds sf to the index, unless there's already a field with the same key.
+func (idx *fieldIndex) add(sf *reflection.StructField) {
+	node := idx
+	for _, keyElem := range sf.AliasedKey {
+		node = node.child(keyElem, true)
+	}
+	if node.field == nil {
+		node.field = sf
+	}
+}
+
+// child returns the child of idx that matches keyElem, or nil if there isn't one. If
+// create is true, the child is created if necessary, and any of keyElem's aliases that
+// aren't yet in use are added for it.
+func (idx *fieldIndex) child(keyElem reflection.AliasedKeyElem, create bool) *fieldIndex {
+	var child *fieldIndex
+	for _, alias := range keyElem {
+		if child = idx.children[foldKey(alias)]; child != nil {
+			break
+		}
+	}
+
+	if !create {
+		return child
+	}
+
+	if child == nil {
+		child = &fieldIndex{name: foldKey(keyElem[0])}
+	}
+	if idx.children == nil {
+		idx.children = make(map[string]*fieldIndex)
+	}
+	for _, alias := range keyElem {
+		if _, exists := idx.children[foldKey(alias)]; !exists {
+			idx.children[foldKey(alias)] = child
+		}
+	}
+	return child
+}
+
+// find is like findStructField, using the index.
+func (idx *fieldIndex) find(targetKey reflection.AliasedKey) (sf *reflection.StructField, exactMatch bool) {
+	node := idx
+	for i, keyElem := range targetKey {
+		if node == nil {
+			break
+		}
+		if node = node.child(keyElem, false); node != nil && node.field != nil {
+			sf, exactMatch = node.field, i == len(targetKey)-1
+		}
+	}
+	return sf, exactMatch
+}
+
+// canonicalKey returns a string form of ak that is the same for all keys that match it
+// (with AliasedKey.Equal, after key elements that are fields in the index have been
+// replaced with their full aliases). It is suitable for keying a map.
+func (idx *fieldIndex) canonicalKey(ak reflection.AliasedKey) string {
+	return joinCanonicalElems(idx.canonicalElems(ak))
+}
+
+// canonicalElems returns the elements of the canonical key of ak (see canonicalKey).
+func (idx *fieldIndex) canonicalElems(ak reflection.AliasedKey) []string {
+	elems := make([]string, len(ak))
+	node := idx
+	for i, keyElem := range ak {
+		if node != nil {
+			node = node.child(keyElem, false)
+		}
+
+		if node != nil {
+			elems[i] = node.name
+		} else {
+			// Beyond the indexed fields (like a key within a map), so there's only one alias
+			elems[i] = foldKey(keyElem[0])
+		}
+	}
+
+	return elems
+}
+
+func joinCanonicalElems(elems []string) string {
+	// NUL can't appear in the keys of any config format we support
+	return strings.Join(elems, "\x00")
+}
+
+// foldKey returns a case-folded form of s, such that foldKey(a) == foldKey(b) if
+// strings.EqualFold(a, b).
+func foldKey(s string) string {
+	isASCII := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			isASCII = false
+			break
+		}
+	}
+	if isASCII {
+		return strings.ToUpper(s)
+	}
+
+	return strings.Map(func(r rune) rune {
+		// Use the smallest rune in r's case-folding orbit (which is what EqualFold
+		// compares against)
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
+
+// mapWriter sets values into a config map by key (see set). It remembers the keys of each
+// sub-map it has visited, so that keys can be matched case-insensitively without scanning
+// the sub-map again for each value set.
+type mapWriter struct {
+	m      map[string]interface{}
+	fields *fieldIndex
+
+	// The case-folded keys of each sub-map visited, keyed by the sub-map's address
+	subMapKeys map[uintptr]*subMapKeys
+}
+
+type subMapKeys struct {
+	// The sub-map itself, so that it can't be freed and its address reused
+	m map[string]interface{}
+
+	// Case-folded key -> actual key
+	keys map[string]string
+}
+
+func newMapWriter(m map[string]interface{}, fields *fieldIndex) *mapWriter {
+	return &mapWriter{m: m, fields: fields, subMapKeys: make(map[uintptr]*subMapKeys)}
+}
+
+// set sets v into the map at k, creating intermediate maps as needed. Elements of k that
+// match struct fields in w.fields are written using the field's alias, unless the map
+// already has a key that matches (case-insensitively).
+func (w *mapWriter) set(k Key, v interface{}) error {
+	aliasedKey := w.aliasedKey(k)
+
+	currMap := w.m
+	for i := range aliasedKey {
+		// The input key might be using struct field names rather than aliases, which
+		// will result in the final unmarshaling not finding those fields. So we'll
+		// prefer to use the alias, which is the last element of AliasedKeyElem.
+		keyElem := aliasedKey[i][len(aliasedKey[i])-1]
+
+		// If the field already exists in the map, use the key/field that's there,
+		// otherwise build the map at keyElem.
+		currKeys := w.keysOf(currMap)
+		if existing, ok := currKeys.find(aliasedKey[i]); ok {
+			keyElem = existing
+		} else {
+			currKeys.keys[foldKey(keyElem)] = keyElem
+		}
+
+		// We're either at the leaf or at an intermediate node.
+		if i == len(aliasedKey)-1 {
+			// Leaf
+			currMap[keyElem] = v
+			break
+		}
+
+		// Intermediate. Make sure it's a map.
+		if currMap[keyElem] == nil {
+			// Either it doesn't exist or it exists and is nil
+			currMap[keyElem] = make(map[string]interface{})
+		} else if _, ok := currMap[keyElem].(map[string]interface{}); !ok {
+			// The map key exists, but is not itself a map. Not okay.
+			return errors.Errorf("Map subtree is not a map; full key: %+v; map subtree key: %+v; map: %+v", k, k[:i+1], w.m)
+		}
+
+		// Get the sub-map for the next iteration of the loop
+		currMap = currMap[keyElem].(map[string]interface{})
+	}
+
+	return nil
+}
+
+// has returns true if the map has a value (possibly nil) at k. Keys are matched as with
+// set.
+func (w *mapWriter) has(k Key) bool {
+	aliasedKey := w.aliasedKey(k)
+
+	currMap := w.m
+	for i := range aliasedKey {
+		mk, ok := w.keysOf(currMap).find(aliasedKey[i])
+		if !ok {
+			return false
+		}
+		if i == len(aliasedKey)-1 {
+			return true
+		}
+		if currMap, ok = currMap[mk].(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return false
+}
+
+// aliasedKey returns the AliasedKey for k, with all the aliases of the struct fields in
+// w.fields that k matches.
+func (w *mapWriter) aliasedKey(k Key) reflection.AliasedKey {
+	aliasedKey := aliasedKeyFromKey(k)
+
+	// We'll try to find an AliasedKey from the provided struct fields (if any). If we
+	// can't find a full match, we'll look for prefixes, as we might be settings a
+	// map-within-a-struct, that only has struct fields up to a certain point, and that
+	// we still want to match.
+	if sf, _ := w.fields.find(aliasedKey); sf != nil {
+		// Found a match. May be a prefix.
+		// Combine this prefix with the rest of the original key.
+		aliasedKey = append(append(reflection.AliasedKey{}, sf.AliasedKey...), aliasedKey[len(sf.AliasedKey):]...)
+	}
+	return aliasedKey
+}
+
+// keysOf returns the case-folded keys of m, collecting them if m hasn't been seen before.
+func (w *mapWriter) keysOf(m map[string]interface{}) *subMapKeys {
+	addr := reflect.ValueOf(m).Pointer()
+	if smk, ok := w.subMapKeys[addr]; ok {
+		return smk
+	}
+
+	smk := &subMapKeys{m: m, keys: make(map[string]string, len(m))}
+	for mk := range m {
+		smk.keys[foldKey(mk)] = mk
+	}
+	w.subMapKeys[addr] = smk
+	return smk
+}
+
+// find returns the actual key in the sub-map that matches one of the aliases in keyElem.
+func (smk *subMapKeys) find(keyElem reflection.AliasedKeyElem) (string, bool) {
+	for _, alias := range keyElem {
+		if mk, ok := smk.keys[foldKey(alias)]; ok {
+			return mk, true
+		}
+	}
+	return "", false
+}
+
+// positionIndex indexes key positions (from a PositionCodec) by canonical key.
+type positionIndex struct {
+	fields    *fieldIndex
+	positions map[string]reflection.KeyPosition
+}
+
+// newPositionIndex creates an index of positions. The keys are compared using the
+// aliases in fields. If there's more than one position for a key, the first is used.
+func newPositionIndex(positions []reflection.KeyPosition, fields *fieldIndex) *positionIndex {
+	pi := &positionIndex{fields: fields, positions: make(map[string]reflection.KeyPosition, len(positions))}
+	for _, p := range positions {
+		if len(p.Key) == 0 {
+			continue
+		}
+		ck := fields.canonicalKey(aliasedKeyFromKey(p.Key))
+		if _, exists := pi.positions[ck]; !exists {
+			pi.positions[ck] = p
+		}
+	}
+	return pi
+}
+
+// find finds the position of k. If there's no position for k itself, the position of its
+// closest ancestor is used (so that, for example, a field within a TOML inline table or
+// a JSON array gets the position of the table or array).
+func (pi *positionIndex) find(k Key) (pos reflection.KeyPosition, found bool) {
+	if len(pi.positions) == 0 {
+		return pos, false
+	}
+
+	elems := pi.fields.canonicalElems(aliasedKeyFromKey(k))
+	for l := len(elems); l > 0; l-- {
+		if pos, found = pi.positions[joinCanonicalElems(elems[:l])]; found {
+			return pos, true
+		}
+	}
+	return pos, false
+}
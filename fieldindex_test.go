@@ -0,0 +1,123 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func TestFieldIndex(t *testing.T) {
+	type config struct {
+		Server struct {
+			ListenPort int `toml:"listen_port"`
+		} `toml:"server"`
+		Labels map[string]string
+		Straße string
+	}
+	fields := reflection.GetStructFields(config{}, TagName, toml.Codec)
+	idx := newFieldIndex(fields)
+
+	tests := []struct {
+		key       Key
+		wantKey   Key // the struct field name key of the field found; nil if none
+		wantExact bool
+	}{
+		{Key{"Server", "ListenPort"}, Key{"Server", "ListenPort"}, true},
+		{Key{"SERVER", "listen_port"}, Key{"Server", "ListenPort"}, true},
+		{Key{"server"}, Key{"Server"}, true},
+		{Key{"server", "nope"}, Key{"Server"}, false},
+		{Key{"labels", "a", "b"}, Key{"Labels"}, false},
+		{Key{"STRASSE"}, nil, false},
+		{Key{"STRAßE"}, Key{"Straße"}, true},
+		{Key{"nope"}, nil, false},
+	}
+	for _, tt := range tests {
+		sf, exact := idx.find(aliasedKeyFromKey(tt.key))
+		var gotKey Key
+		if sf != nil {
+			for _, keyElem := range sf.AliasedKey {
+				gotKey = append(gotKey, keyElem[0])
+			}
+		}
+		if !reflect.DeepEqual(gotKey, tt.wantKey) || exact != tt.wantExact {
+			t.Fatalf("find(%v) mismatch; got %v %v, want %v %v", tt.key, gotKey, exact, tt.wantKey, tt.wantExact)
+		}
+
+		// Must agree with AliasedKey.Equal, as a scan of the fields does
+		for _, f := range fields {
+			if f.AliasedKey.Equal(aliasedKeyFromKey(tt.key)) && (sf != f || !exact) {
+				t.Fatalf("find(%v) disagrees with Equal for %v", tt.key, f)
+			}
+		}
+	}
+
+	if idx.canonicalKey(aliasedKeyFromKey(Key{"Server", "ListenPort"})) != idx.canonicalKey(aliasedKeyFromKey(Key{"server", "LISTEN_PORT"})) {
+		t.Fatalf("canonical keys of aliases should match")
+	}
+	if idx.canonicalKey(aliasedKeyFromKey(Key{"Labels", "a"})) != idx.canonicalKey(aliasedKeyFromKey(Key{"labels", "A"})) {
+		t.Fatalf("canonical keys of map entries should match case-insensitively")
+	}
+	if idx.canonicalKey(aliasedKeyFromKey(Key{"Labels", "a"})) == idx.canonicalKey(aliasedKeyFromKey(Key{"Labels", "b"})) {
+		t.Fatalf("canonical keys of different map entries should differ")
+	}
+
+	var nilIdx *fieldIndex
+	if sf, exact := nilIdx.find(aliasedKeyFromKey(Key{"a"})); sf != nil || exact {
+		t.Fatalf("nil index should be empty")
+	}
+
+	positions := newPositionIndex([]reflection.KeyPosition{
+		{Key: []string{"server"}, Line: 1, Column: 1},
+		{Key: []string{"server", "listen_port"}, Line: 2, Column: 1},
+		{Key: []string{"server", "listen_port"}, Line: 9, Column: 9},
+	}, idx)
+	if pos, found := positions.find(Key{"Server", "ListenPort"}); !found || pos.Line != 2 {
+		t.Fatalf("position mismatch; got %+v %v", pos, found)
+	}
+	if pos, found := positions.find(Key{"Server", "Other"}); !found || pos.Line != 1 {
+		t.Fatalf("ancestor position mismatch; got %+v %v", pos, found)
+	}
+	if _, found := positions.find(Key{"Labels"}); found {
+		t.Fatalf("unexpected position for Labels")
+	}
+}
+
+func TestMapWriter(t *testing.T) {
+	m := map[string]interface{}{"Existing": map[string]interface{}{}}
+	w := newMapWriter(m, nil)
+
+	sets := []struct {
+		k Key
+		v interface{}
+	}{
+		{Key{"existing", "a"}, 1},
+		{Key{"EXISTING", "A"}, 2},
+		{Key{"new", "b"}, 3},
+		{Key{"NEW", "c"}, 4},
+	}
+	for _, s := range sets {
+		if err := w.set(s.k, s.v); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	want := map[string]interface{}{
+		"Existing": map[string]interface{}{"a": 2},
+		"new":      map[string]interface{}{"b": 3, "c": 4},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("map mismatch;\ngot  %#v\nwant %#v", m, want)
+	}
+
+	if err := w.set(Key{"new", "b", "x"}, 5); err == nil {
+		t.Fatalf("expected error setting into a non-map")
+	}
+}
@@ -7,15 +7,21 @@
 package configloader
 
 import (
+	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// Assigning to a variable to assist with testing (to force errors)
+// Assigning to variables to assist with testing (to force errors)
 var osOpen = os.Open
+var osUserHomeDir = os.UserHomeDir
 
 // FileLocation is the name of a (potential) config file, and the places where it should
 // be looked for.
@@ -26,18 +32,78 @@ type FileLocation struct {
 
 	// The file will be search for through the SearchPaths. These are in order -- the
 	// search will stop on the first match.
+	// A leading "~" is expanded to the user's home directory, and environment variables
+	// of the form $VAR or ${VAR} are expanded (see ExpandPath). Search paths that refer
+	// to environment variables that aren't set are skipped.
 	SearchPaths []string
+
+	// By default, the first FileLocation passed to FindFiles is required and the rest
+	// are optional. Required forces this file to be required (useful for a file that
+	// isn't first) and Optional forces it to be optional (useful for a first file that
+	// may be legitimately absent). Setting both is an error.
+	Required bool
+	Optional bool
+
+	// The codec to use when decoding the file. If nil, the codec registered for the file
+	// extension (via RegisterCodec) will be used, if any. If there is no codec for the
+	// file, the codec passed to Load() will be used.
+	Codec Codec
+}
+
+// isRequired returns true if the file at this location must be found. first indicates
+// whether this location is the first one passed to FindFiles.
+func (loc FileLocation) isRequired(first bool) bool {
+	if loc.Required {
+		return true
+	}
+	if loc.Optional {
+		return false
+	}
+	return first
+}
+
+// MissingFile describes a required file that FindFiles could not find.
+type MissingFile struct {
+	// The Filename from the FileLocation.
+	Filename string
+
+	// The full (expanded) paths that were tried, in order.
+	TriedPaths []string
+}
+
+// FilesNotFoundError is returned by FindFiles and FindFilesFS when one or more required files could not
+// be found in any of their search paths.
+type FilesNotFoundError struct {
+	// All of the required files that were not found, in FileLocation order.
+	Missing []MissingFile
+}
+
+func (e *FilesNotFoundError) Error() string {
+	missingStrings := make([]string, len(e.Missing))
+	for i, mf := range e.Missing {
+		missingStrings[i] = fmt.Sprintf("'%s' (tried: %s)", mf.Filename, strings.Join(mf.TriedPaths, ", "))
+	}
+	return fmt.Sprintf("failed to find required files: %s", strings.Join(missingStrings, "; "))
 }
 
 // FindFiles assists with figuring out which config files should be used.
 //
 // fileLocations is the location info for the files that will contribute to this config.
-// All files will be used, and each will be merged on top of the previous ones. The first
-// file must exist (in at least one of the search paths), but subsequent files are
-// optional. The intention is that the first file is the primary config, and the other
-// files optionally override that.
+// All files will be used, and each will be merged on top of the previous ones. By
+// default the first file must exist (in at least one of the search paths), but subsequent
+// files are optional. The intention is that the first file is the primary config, and the
+// other files optionally override that. This can be changed per-location with
+// FileLocation.Required and FileLocation.Optional.
+//
+// If any required files are not found, the returned error will be a *FilesNotFoundError
+// listing every path tried for each of them.
+//
+// Search paths are expanded with ExpandPath before use, so the returned readerNames
+// contain the expanded paths.
 //
 // The returned readers and readerNames are intended to be passed directly to configloader.Load().
+// Readers for files with a codec (see FileLocation.Codec) are wrapped with
+// ReaderWithCodec, so Load() will decode each file in its own format.
 // The closers should be closed after Load() is called, perhaps like this:
 //  defer func() {
 //    for i := range closers {
@@ -48,6 +114,73 @@ type FileLocation struct {
 // both to ease passing into Load() and to help ensure the closing happens (via and
 // "unused variable" compile error).
 func FindFiles(fileLocations ...FileLocation) (readers []io.Reader, closers []io.Closer, readerNames []string, err error) {
+	return diskFileFinder().findFiles(fileLocations)
+}
+
+// diskFileFinder returns the fileFinder used by FindFiles.
+func diskFileFinder() fileFinder {
+	return fileFinder{
+		expand: ExpandPath,
+		join:   filepath.Join,
+		open: func(name string) (io.ReadCloser, error) {
+			f, err := osOpen(name)
+			if err != nil {
+				// Avoid returning a non-nil interface holding a nil *os.File
+				return nil, err
+			}
+			return f, nil
+		},
+		readerName: filepath.ToSlash,
+	}
+}
+
+// FSReaderNamePrefix is prepended to the readerNames returned by FindFilesFS, to make it
+// clear in provenances that a value came from an fs.FS (such as an embed.FS) rather than
+// from disk.
+const FSReaderNamePrefix = "fs:"
+
+// FindFilesFS is like FindFiles, but finds files within fsys (such as an embed.FS or
+// fstest.MapFS) rather than on disk.
+//
+// Filenames and search paths must use forward slashes and be relative to the root of
+// fsys, as required by fs.ValidPath. No "~" or environment variable expansion is done.
+//
+// The returned readerNames are prefixed with FSReaderNamePrefix, like "fs:config.toml".
+//
+// The results of FindFilesFS and FindFiles can be combined to layer disk overrides on top
+// of embedded config, like so:
+//  readers := append(fsReaders, diskReaders...)
+//  readerNames := append(fsReaderNames, diskReaderNames...)
+func FindFilesFS(fsys fs.FS, fileLocations ...FileLocation) (readers []io.Reader, closers []io.Closer, readerNames []string, err error) {
+	finder := fileFinder{
+		expand: func(p string) (string, error) { return p, nil },
+		join:   path.Join,
+		open: func(name string) (io.ReadCloser, error) {
+			return fsys.Open(name)
+		},
+		readerName: func(fpath string) string {
+			return FSReaderNamePrefix + fpath
+		},
+	}
+	return finder.findFiles(fileLocations)
+}
+
+// fileFinder captures the differences between finding files on disk and within an fs.FS.
+type fileFinder struct {
+	// Expands a search path before it is joined with the filename
+	expand func(searchPath string) (string, error)
+	// Joins a search path and a filename
+	join func(elem ...string) string
+	// Opens the file at the joined path
+	open func(name string) (io.ReadCloser, error)
+	// Converts the joined path into a reader name
+	readerName func(fpath string) string
+	// Where warnings are logged; if nil, the Logger variable is used
+	logger *slog.Logger
+}
+
+// Implementation of FindFiles and FindFilesFS
+func (ff fileFinder) findFiles(fileLocations []FileLocation) (readers []io.Reader, closers []io.Closer, readerNames []string, err error) {
 	if len(fileLocations) == 0 {
 		err = errors.Errorf("no filenames provided")
 		return nil, nil, nil, err
@@ -59,35 +192,148 @@ func FindFiles(fileLocations ...FileLocation) (readers []io.Reader, closers []io
 			for i := range closers {
 				closers[i].Close()
 			}
+			readers, closers, readerNames = nil, nil, nil
 		}
 	}()
 
+	var notFoundErr *FilesNotFoundError
+
 FilenamesLoop:
 	for i, loc := range fileLocations {
-		for _, path := range loc.SearchPaths {
-			fpath := filepath.Join(path, loc.Filename)
-			var f *os.File
-			f, err := osOpen(fpath)
+		if loc.Required && loc.Optional {
+			err = errors.Errorf("file location cannot be both required and optional: '%v'", loc.Filename)
+			return readers, closers, readerNames, err
+		}
+
+		var triedPaths []string
+		for _, searchPath := range loc.SearchPaths {
+			expandedPath, err := ff.expand(searchPath)
+			if unsetErr, ok := err.(*UnsetEnvVarError); ok {
+				// The search path doesn't apply in this environment
+				logWarn(ff.logger, "configloader: search path skipped; environment variable not set",
+					"searchPath", searchPath, "envVar", unsetErr.Name)
+				continue
+			} else if err != nil {
+				err = errors.Wrapf(err, "search path expansion failed for %s", searchPath)
+				return readers, closers, readerNames, err
+			}
+
+			fpath := ff.join(expandedPath, loc.Filename)
+			f, err := ff.open(fpath)
 			if os.IsNotExist(err) {
+				triedPaths = append(triedPaths, ff.readerName(fpath))
 				continue
 			} else if err != nil {
 				err = errors.Wrapf(err, "file open failed for %s", fpath)
-				return nil, nil, nil, err
+				return readers, closers, readerNames, err
 			}
 
-			readers = append(readers, f)
+			fileCodec := loc.Codec
+			if fileCodec == nil {
+				fileCodec = CodecForFilename(fpath)
+			}
+
+			readers = append(readers, ReaderWithCodec(f, fileCodec))
 			closers = append(closers, f)
-			readerNames = append(readerNames, filepath.ToSlash(fpath))
+			readerNames = append(readerNames, ff.readerName(fpath))
 			continue FilenamesLoop
 		}
 
-		// We failed to find the file in the search paths. This is only an error if this
-		// is the first filename in filenames (i.e., not an override).
-		if i == 0 {
-			err = errors.Errorf("failed to find file '%v' in search paths: %+v", loc.Filename, loc.SearchPaths)
-			return nil, nil, nil, err
+		// We failed to find the file in the search paths. This is only an error if the
+		// file is required. We'll keep going so that all missing files get reported.
+		if loc.isRequired(i == 0) {
+			if notFoundErr == nil {
+				notFoundErr = &FilesNotFoundError{}
+			}
+			notFoundErr.Missing = append(notFoundErr.Missing, MissingFile{
+				Filename:   loc.Filename,
+				TriedPaths: triedPaths,
+			})
 		}
 	}
 
+	if notFoundErr != nil {
+		err = notFoundErr
+		return readers, closers, readerNames, err
+	}
+
 	return readers, closers, readerNames, nil
 }
+
+// UnsetEnvVarError is returned by ExpandPath when path refers to an environment
+// variable that isn't set.
+type UnsetEnvVarError struct {
+	// The name of the environment variable
+	Name string
+}
+
+func (e *UnsetEnvVarError) Error() string {
+	return fmt.Sprintf("environment variable not set: %s", e.Name)
+}
+
+// ExpandPath expands a leading "~" (alone or followed by a path separator) to the
+// current user's home directory, and expands environment variables of the form $VAR or
+// ${VAR}. If an environment variable isn't set, an *UnsetEnvVarError is returned (rather
+// than replacing it with the empty string, which would turn a path like
+// "$XDG_CONFIG_HOME/app" into "/app"). A variable that is set to the empty string is
+// replaced with it.
+// "~user" forms are not supported and are left as-is.
+func ExpandPath(path string) (string, error) {
+	var unsetErr *UnsetEnvVarError
+	path = os.Expand(path, func(name string) string {
+		val, ok := os.LookupEnv(name)
+		if !ok && unsetErr == nil {
+			unsetErr = &UnsetEnvVarError{Name: name}
+		}
+		return val
+	})
+	if unsetErr != nil {
+		return "", unsetErr
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		home, err := osUserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to determine user home directory")
+		}
+		path = filepath.Join(home, path[1:])
+	}
+
+	return path, nil
+}
+
+// XDGSearchPaths returns the config search paths for appName as described by the XDG
+// Base Directory Specification, in order of preference:
+//   $XDG_CONFIG_HOME/appName (defaulting to ~/.config/appName)
+//   each directory in $XDG_CONFIG_DIRS, with appName appended (defaulting to /etc/xdg/appName)
+// Relative paths in the environment variables are ignored, as required by the spec.
+// If the home directory can't be determined, the ~/.config entry is omitted.
+// The result is intended to be used as FileLocation.SearchPaths, possibly combined with
+// other paths (like ".").
+func XDGSearchPaths(appName string) []string {
+	var paths []string
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(configHome) {
+		configHome = ""
+		if home, err := osUserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, appName))
+	}
+
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(configDirs) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, appName))
+	}
+
+	return paths
+}
@@ -10,9 +10,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestFindFiles(t *testing.T) {
@@ -20,6 +22,8 @@ func TestFindFiles(t *testing.T) {
 		name            string
 		fileLocations   []FileLocation
 		osOpen          func(name string) (*os.File, error)
+		osUserHomeDir   func() (string, error)
+		env             map[string]string
 		wantReaderNames []string
 		wantErr         bool
 	}{
@@ -234,6 +238,175 @@ func TestFindFiles(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "unset env var in search path is skipped",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"${FINDFILES_TEST_UNSET}/testdata", "testdata"},
+				},
+			},
+			wantReaderNames: []string{
+				"testdata/file1",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error: only search path has unset env var",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"$FINDFILES_TEST_UNSET"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "env var in search path",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"$FINDFILES_TEST_DIR"},
+				},
+				{
+					Filename:    "file3",
+					SearchPaths: []string{"${FINDFILES_TEST_DIR}/subdir1"},
+				},
+			},
+			env: map[string]string{
+				"FINDFILES_TEST_DIR": "testdata",
+			},
+			wantReaderNames: []string{
+				"testdata/file1",
+				"testdata/subdir1/file3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "undefined env var in search path",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"$FINDFILES_TEST_UNDEFINED/testdata"},
+				},
+			},
+			wantReaderNames: nil,
+			wantErr:         true,
+		},
+		{
+			name: "home dir in search path",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"~"},
+				},
+				{
+					Filename:    "file3",
+					SearchPaths: []string{"~/subdir1"},
+				},
+			},
+			osUserHomeDir: func() (string, error) {
+				return "testdata", nil
+			},
+			wantReaderNames: []string{
+				"testdata/file1",
+				"testdata/subdir1/file3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error: home dir lookup fails",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"~/subdir1"},
+				},
+			},
+			osUserHomeDir: func() (string, error) {
+				return "", fmt.Errorf("oh no no home")
+			},
+			wantErr: true,
+		},
+		{
+			name: "optional first file missing",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "nonexistent",
+					SearchPaths: []string{"testdata"},
+					Optional:    true,
+				},
+				{
+					Filename:    "file2",
+					SearchPaths: []string{"testdata"},
+				},
+			},
+			wantReaderNames: []string{
+				"testdata/file2",
+			},
+			wantErr: false,
+		},
+		{
+			name: "all optional, none found",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "nonexistent",
+					SearchPaths: []string{"testdata"},
+					Optional:    true,
+				},
+				{
+					Filename:    "nonexistent2",
+					SearchPaths: []string{"testdata"},
+				},
+			},
+			wantReaderNames: nil,
+			wantErr:         false,
+		},
+		{
+			name: "required subsequent file found",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"testdata"},
+				},
+				{
+					Filename:    "file3",
+					SearchPaths: []string{"testdata", "testdata/subdir1"},
+					Required:    true,
+				},
+			},
+			wantReaderNames: []string{
+				"testdata/file1",
+				"testdata/subdir1/file3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error: required subsequent file missing",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"testdata"},
+				},
+				{
+					Filename:    "nonexistent",
+					SearchPaths: []string{"testdata"},
+					Required:    true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error: both required and optional",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"testdata"},
+					Required:    true,
+					Optional:    true,
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name:          "error: no file locations provided",
 			fileLocations: []FileLocation{},
@@ -255,6 +428,12 @@ func TestFindFiles(t *testing.T) {
 			if tt.osOpen != nil {
 				osOpen = tt.osOpen
 			}
+			if tt.osUserHomeDir != nil {
+				osUserHomeDir = tt.osUserHomeDir
+			}
+			for envKey, envVal := range tt.env {
+				os.Setenv(envKey, envVal)
+			}
 
 			gotReaders, gotClosers, gotReaderNames, err := FindFiles(tt.fileLocations...)
 
@@ -266,8 +445,12 @@ func TestFindFiles(t *testing.T) {
 				}
 			}()
 
-			// Restore the original function
+			// Restore the original functions and environment
 			osOpen = os.Open
+			osUserHomeDir = os.UserHomeDir
+			for envKey := range tt.env {
+				os.Unsetenv(envKey)
+			}
 
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("FindFiles() error = %v, wantErr %v", err, tt.wantErr)
@@ -301,3 +484,270 @@ func TestFindFiles(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandPath(t *testing.T) {
+	os.Unsetenv("EXPANDPATH_TEST_UNSET")
+	os.Setenv("EXPANDPATH_TEST_EMPTY", "")
+	os.Setenv("EXPANDPATH_TEST_SET", "/set")
+	defer os.Unsetenv("EXPANDPATH_TEST_EMPTY")
+	defer os.Unsetenv("EXPANDPATH_TEST_SET")
+
+	got, err := ExpandPath("${EXPANDPATH_TEST_SET}/app$EXPANDPATH_TEST_EMPTY")
+	if err != nil || got != "/set/app" {
+		t.Fatalf("ExpandPath() = %q, %v; want /set/app", got, err)
+	}
+
+	_, err = ExpandPath("${EXPANDPATH_TEST_UNSET}/app")
+	if unsetErr, ok := err.(*UnsetEnvVarError); !ok || unsetErr.Name != "EXPANDPATH_TEST_UNSET" {
+		t.Fatalf("expected UnsetEnvVarError for EXPANDPATH_TEST_UNSET; got %v", err)
+	}
+}
+
+func TestFindFiles_FilesNotFoundError(t *testing.T) {
+	fileLocations := []FileLocation{
+		{
+			Filename:    "nonexistent1",
+			SearchPaths: []string{"testdata", "testdata/subdir1"},
+		},
+		{
+			Filename:    "file1",
+			SearchPaths: []string{"testdata"},
+			Required:    true,
+		},
+		{
+			Filename:    "nonexistent2",
+			SearchPaths: []string{"testdata/subdir1"},
+		},
+		{
+			Filename:    "nonexistent3",
+			SearchPaths: []string{"testdata/nonexistent", "testdata"},
+			Required:    true,
+		},
+	}
+
+	_, closers, _, err := FindFiles(fileLocations...)
+	if len(closers) != 0 {
+		t.Fatalf("closers should be empty on error; got %d", len(closers))
+	}
+
+	notFoundErr, ok := err.(*FilesNotFoundError)
+	if !ok {
+		t.Fatalf("error should be *FilesNotFoundError; got %T: %v", err, err)
+	}
+
+	wantMissing := []MissingFile{
+		{
+			Filename:   "nonexistent1",
+			TriedPaths: []string{"testdata/nonexistent1", "testdata/subdir1/nonexistent1"},
+		},
+		{
+			Filename:   "nonexistent3",
+			TriedPaths: []string{"testdata/nonexistent/nonexistent3", "testdata/nonexistent3"},
+		},
+	}
+	if !reflect.DeepEqual(notFoundErr.Missing, wantMissing) {
+		t.Fatalf("FilesNotFoundError.Missing mismatch\ngot:  %+v\nwant: %+v", notFoundErr.Missing, wantMissing)
+	}
+
+	wantErrString := "failed to find required files: 'nonexistent1' (tried: testdata/nonexistent1, testdata/subdir1/nonexistent1); 'nonexistent3' (tried: testdata/nonexistent/nonexistent3, testdata/nonexistent3)"
+	if notFoundErr.Error() != wantErrString {
+		t.Fatalf("FilesNotFoundError.Error() mismatch\ngot:  %s\nwant: %s", notFoundErr.Error(), wantErrString)
+	}
+}
+
+func TestFindFilesFS(t *testing.T) {
+	// File contents are the FS path, so they can be checked against the reader names
+	fsys := fstest.MapFS{
+		"file1":         {Data: []byte("file1")},
+		"file2":         {Data: []byte("file2")},
+		"subdir1/file1": {Data: []byte("subdir1/file1")},
+		"subdir1/file3": {Data: []byte("subdir1/file3")},
+	}
+
+	tests := []struct {
+		name            string
+		fileLocations   []FileLocation
+		wantReaderNames []string
+		wantErr         bool
+	}{
+		{
+			name: "simple; one file, root path",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"."},
+				},
+			},
+			wantReaderNames: []string{
+				"fs:file1",
+			},
+			wantErr: false,
+		},
+		{
+			name: "empty path, path in filename",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "subdir1/file1",
+					SearchPaths: []string{""},
+				},
+			},
+			wantReaderNames: []string{
+				"fs:subdir1/file1",
+			},
+			wantErr: false,
+		},
+		{
+			name: "mutiple files, some overrides existing",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"subdir1", "."},
+				},
+				{
+					Filename:    "file1_override",
+					SearchPaths: []string{"subdir1", "."},
+				},
+				{
+					Filename:    "file3",
+					SearchPaths: []string{"nonexistent", ".", "subdir1"},
+				},
+			},
+			wantReaderNames: []string{
+				"fs:subdir1/file1",
+				"fs:subdir1/file3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "error: no such file",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "nonexistent",
+					SearchPaths: []string{".", "subdir1"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "error: invalid path",
+			fileLocations: []FileLocation{
+				{
+					Filename:    "file1",
+					SearchPaths: []string{"/abs"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotReaders, gotClosers, gotReaderNames, err := FindFilesFS(fsys, tt.fileLocations...)
+
+			defer func() {
+				for i := range gotClosers {
+					if err := gotClosers[i].Close(); err != nil {
+						t.Fatalf("failed to close closer for '%v': %v", gotReaderNames[i], err)
+					}
+				}
+			}()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindFilesFS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(gotReaderNames, tt.wantReaderNames) {
+				t.Fatalf("FindFilesFS() gotReaderNames = %v, want %v", gotReaderNames, tt.wantReaderNames)
+			}
+
+			if len(gotReaders) != len(gotReaderNames) || len(gotReaders) != len(gotClosers) {
+				t.Fatalf("length mismatch: len(gotReaders)=%v; len(gotReaderNames)=%v; len(gotClosers)=%v", len(gotReaders), len(gotReaderNames), len(gotClosers))
+			}
+
+			for i := range gotReaders {
+				buf, err := ioutil.ReadAll(gotReaders[i])
+				if err != nil {
+					t.Fatalf("failed to read reader with name '%v'", gotReaderNames[i])
+				}
+
+				if FSReaderNamePrefix+string(buf) != gotReaderNames[i] {
+					t.Fatalf("file contents should match reader name;\nfileContents: %s\nreaderName: %v", buf, gotReaderNames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestXDGSearchPaths(t *testing.T) {
+	tests := []struct {
+		name          string
+		appName       string
+		env           map[string]string
+		osUserHomeDir func() (string, error)
+		want          []string
+	}{
+		{
+			name:    "defaults",
+			appName: "myapp",
+			osUserHomeDir: func() (string, error) {
+				return "/home/me", nil
+			},
+			want: []string{"/home/me/.config/myapp", "/etc/xdg/myapp"},
+		},
+		{
+			name:    "env vars set",
+			appName: "myapp",
+			env: map[string]string{
+				"XDG_CONFIG_HOME": "/xdg/home",
+				"XDG_CONFIG_DIRS": "/xdg/dir1:/xdg/dir2",
+			},
+			osUserHomeDir: func() (string, error) {
+				return "/home/me", nil
+			},
+			want: []string{"/xdg/home/myapp", "/xdg/dir1/myapp", "/xdg/dir2/myapp"},
+		},
+		{
+			name:    "relative paths ignored",
+			appName: "myapp",
+			env: map[string]string{
+				"XDG_CONFIG_HOME": "relative/home",
+				"XDG_CONFIG_DIRS": "relative/dir:/xdg/dir",
+			},
+			osUserHomeDir: func() (string, error) {
+				return "/home/me", nil
+			},
+			want: []string{"/home/me/.config/myapp", "/xdg/dir/myapp"},
+		},
+		{
+			name:    "no home dir",
+			appName: "myapp",
+			osUserHomeDir: func() (string, error) {
+				return "", fmt.Errorf("oh no no home")
+			},
+			want: []string{"/etc/xdg/myapp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Unsetenv("XDG_CONFIG_HOME")
+			os.Unsetenv("XDG_CONFIG_DIRS")
+			for envKey, envVal := range tt.env {
+				os.Setenv(envKey, envVal)
+			}
+			osUserHomeDir = tt.osUserHomeDir
+
+			got := XDGSearchPaths(tt.appName)
+
+			osUserHomeDir = os.UserHomeDir
+			for envKey := range tt.env {
+				os.Unsetenv(envKey)
+			}
+
+			for i := range got {
+				got[i] = filepath.ToSlash(got[i])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("XDGSearchPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
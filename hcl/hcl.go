@@ -0,0 +1,266 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package hcl provides HCL (version 1) Codec methods for use with configloader.
+//
+// It is a separate package so that users who don't need HCL don't pick up the dependency.
+//
+// HCL blocks (like `server { ... }`) decode to slices of maps. To fit configloader's
+// map-based model, blocks are collapsed into plain maps: a block that appears once
+// becomes a map, and repeated blocks (including labeled blocks like `backend "eu" {...}`)
+// are merged into a single map. So blocks always decode to maps (or structs), whatever
+// the data, and it is an error for repeated blocks to have conflicting keys. Lists of
+// objects must be written with list syntax (`d = [{...}, {...}]`), and are always lists.
+//
+// HCL has no encoder, so Marshal produces JSON (which HCL accepts as input).
+//
+// configloader.Load() decodes into result structs itself, so all field types are
+// supported, including unsigned integers and encoding.TextUnmarshaler fields (like
+// time.Time). Calling Unmarshal directly with a struct uses the HCL decoder, which
+// supports neither.
+package hcl
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/hashicorp/hcl"
+	"github.com/pkg/errors"
+)
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(toMarshalable(reflect.ValueOf(v)))
+}
+
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(*map[string]interface{})
+	if !ok {
+		return hcl.Unmarshal(data, v)
+	}
+
+	var decoded map[string]interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		// This is JSON (probably produced by our Marshal). HCL would decode JSON objects
+		// like blocks, which would make lists of objects indistinguishable from blocks,
+		// so we'll decode it as plain JSON.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&decoded); err != nil {
+			return err
+		}
+		decoded = convertJSONNumbers(decoded).(map[string]interface{})
+	} else {
+		if err := hcl.Unmarshal(data, &decoded); err != nil {
+			return err
+		}
+		collapsed, err := collapseBlocks(decoded, nil)
+		if err != nil {
+			return err
+		}
+		decoded = collapsed.(map[string]interface{})
+	}
+
+	if *m == nil {
+		*m = make(map[string]interface{})
+	}
+	for k, val := range decoded {
+		(*m)[k] = val
+	}
+	return nil
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("hcl") == "-"
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	if codec.IsStructFieldIgnored(st) {
+		return ""
+	}
+
+	if typeTag := st.Get("hcl"); typeTag != "" {
+		return strings.Split(typeTag, ",")[0]
+	}
+
+	return ""
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	// HCL produces int for numbers without a decimal point, even for float fields
+	if strings.HasPrefix(check.Kind, "int") && strings.HasPrefix(gold.Kind, "float") {
+		return true, nil
+	}
+
+	return false, errors.Errorf("field types inconsistent")
+}
+
+// collapseBlocks recursively converts the []map[string]interface{} values that HCL
+// produces for blocks into maps, as described in the package doc. key is the key of v,
+// used for error messages.
+func collapseBlocks(v interface{}, key []string) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, val := range vv {
+			collapsed, err := collapseBlocks(val, append(key[:len(key):len(key)], k))
+			if err != nil {
+				return nil, err
+			}
+			m[k] = collapsed
+		}
+		return m, nil
+
+	case []map[string]interface{}:
+		merged := make(map[string]interface{})
+		for _, block := range vv {
+			collapsed, err := collapseBlocks(block, key)
+			if err != nil {
+				return nil, err
+			}
+			if conflict := mergeBlock(merged, collapsed.(map[string]interface{})); conflict != nil {
+				return nil, errors.Errorf("repeated '%s' blocks have conflicting values for '%s'; use list syntax (like `%s = [{...}, {...}]`) for a list",
+					strings.Join(key, "."), strings.Join(append(key[:len(key):len(key)], conflict...), "."), strings.Join(key, "."))
+			}
+		}
+		return merged, nil
+
+	case []interface{}:
+		list := make([]interface{}, len(vv))
+		for i := range vv {
+			collapsed, err := collapseBlocks(vv[i], key)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = collapsed
+		}
+		return list, nil
+	}
+
+	return v, nil
+}
+
+// mergeBlock merges src into dst, recursing into maps that are present in both. If
+// there's a conflicting non-map key, its key (relative to dst) is returned, and dst is
+// partially modified.
+func mergeBlock(dst, src map[string]interface{}) (conflict []string) {
+	for k, srcVal := range src {
+		dstVal, exists := dst[k]
+		if !exists {
+			dst[k] = srcVal
+			continue
+		}
+
+		dstMap, dstIsMap := dstVal.(map[string]interface{})
+		srcMap, srcIsMap := srcVal.(map[string]interface{})
+		if !dstIsMap || !srcIsMap {
+			return []string{k}
+		}
+		if conflict := mergeBlock(dstMap, srcMap); conflict != nil {
+			return append([]string{k}, conflict...)
+		}
+	}
+	return nil
+}
+
+// convertJSONNumbers recursively converts json.Number values into int (like HCL
+// produces) if they're integers, or float64 otherwise.
+func convertJSONNumbers(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k := range vv {
+			vv[k] = convertJSONNumbers(vv[k])
+		}
+	case []interface{}:
+		for i := range vv {
+			vv[i] = convertJSONNumbers(vv[i])
+		}
+	case json.Number:
+		if i, err := vv.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := vv.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// toMarshalable converts structs within v into maps keyed by their HCL names, so that
+// they can be marshaled as JSON with the correct keys.
+func toMarshalable(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Type().Implements(textMarshalerType) || v.Type().Implements(jsonMarshalerType) {
+		// Let encoding/json handle it
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return toMarshalable(v.Elem())
+
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || Codec.IsStructFieldIgnored(field.Tag) {
+				continue
+			}
+
+			name := field.Name
+			if alias := Codec.GetStructFieldAlias(field.Tag); alias != "" {
+				name = alias
+			}
+			m[name] = toMarshalable(v.Field(i))
+		}
+		return m
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			if k.Kind() != reflect.String {
+				// Let encoding/json deal with (or reject) non-string keys
+				return v.Interface()
+			}
+			m[k.String()] = toMarshalable(v.MapIndex(k))
+		}
+		return m
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		list := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			list[i] = toMarshalable(v.Index(i))
+		}
+		return list
+	}
+
+	return v.Interface()
+}
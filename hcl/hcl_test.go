@@ -0,0 +1,201 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package hcl_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/hcl"
+)
+
+func TestLoad(t *testing.T) {
+	type backend struct {
+		URL string `hcl:"url"`
+	}
+	type rule struct {
+		Name string `hcl:"name"`
+	}
+	type config struct {
+		Server struct {
+			ListenPort int `hcl:"listen_port"`
+			Hostname   string
+			Ratio      float64 `hcl:"ratio"`
+		} `hcl:"server"`
+		Backends map[string]backend `hcl:"backend"`
+		Rules    []rule             `hcl:"rules" conf:"optional"`
+		Ignored  string             `hcl:"-"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+server {
+  listen_port = 80
+  hostname = "example.com"
+}
+
+server {
+  ratio = 1
+}
+
+backend "eu" {
+  url = "https://eu.example.com"
+}
+
+backend "us" {
+  url = "https://us.example.com"
+}
+
+rules = [{name = "a"}, {name = "b"}]
+`),
+		strings.NewReader(`
+server {
+  listen_port = 8080
+}
+`),
+	}
+
+	var result config
+	md, err := configloader.Load(hcl.Codec, readers, []string{"config.hcl", "override.hcl"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Server.ListenPort = 8080
+	want.Server.Hostname = "example.com"
+	want.Server.Ratio = 1
+	want.Backends = map[string]backend{
+		"eu": {URL: "https://eu.example.com"},
+		"us": {URL: "https://us.example.com"},
+	}
+	want.Rules = []rule{{Name: "a"}, {Name: "b"}}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	wantProvs := map[string]string{
+		"server.listen_port": "override.hcl",
+		"server.Hostname":    "config.hcl",
+		"server.ratio":       "config.hcl",
+		"backend.eu.url":     "config.hcl",
+		"backend.us.url":     "config.hcl",
+		"rules":              "config.hcl",
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		if wantProvs[prov.Key.String()] != prov.Src {
+			t.Fatalf("provenance mismatch for %v;\ngot  %v\nwant %v", prov.Key, md.Provenances, wantProvs)
+		}
+	}
+
+	wantConfigMap := map[string]interface{}{
+		"server": map[string]interface{}{
+			"listen_port": int64(8080),
+			"Hostname":    "example.com",
+			"ratio":       float64(1),
+		},
+		"backend": map[string]interface{}{
+			"eu": map[string]interface{}{"url": "https://eu.example.com"},
+			"us": map[string]interface{}{"url": "https://us.example.com"},
+		},
+		"rules": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+	if !reflect.DeepEqual(md.ConfigMap, wantConfigMap) {
+		t.Fatalf("ConfigMap mismatch;\ngot  %#v\nwant %#v", md.ConfigMap, wantConfigMap)
+	}
+}
+
+func TestLoad_Map(t *testing.T) {
+	readers := []io.Reader{strings.NewReader(`
+a {
+  b = 1
+}
+
+item {
+  name = "x"
+}
+
+item {
+  size = 2
+}
+
+list = [{name = "x"}]
+`)}
+
+	var result map[string]interface{}
+	_, err := configloader.Load(hcl.Codec, readers, nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": 1,
+		},
+		// Repeated blocks are merged into a map, even in map results
+		"item": map[string]interface{}{"name": "x", "size": 2},
+		// List syntax is always a list, even with one element
+		"list": []interface{}{
+			map[string]interface{}{"name": "x"},
+		},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	// Repeated blocks with conflicting keys are an error, rather than becoming a list
+	readers = []io.Reader{strings.NewReader(`
+item {
+  name = "x"
+}
+
+item {
+  name = "y"
+}
+`)}
+	result = nil
+	_, err = configloader.Load(hcl.Codec, readers, nil, nil, nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "conflicting values for 'item.name'") {
+		t.Fatalf("expected conflicting blocks error; got %v", err)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	type config struct {
+		A int    `hcl:"a"`
+		B string `hcl:"-" conf:"optional"`
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"vestigial field", "a = 1\nc = 2\n"},
+		{"ignored field", "a = 1\nb = \"x\"\n"},
+		{"type mismatch", "a = \"abc\"\n"},
+		{"missing required", "\n"},
+		{"bad hcl", "a = = 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := configloader.Load(hcl.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, nil, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+		})
+	}
+}
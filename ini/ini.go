@@ -0,0 +1,220 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package ini provides INI Codec methods for use with configloader.
+//
+// The supported syntax is:
+//  ; comment
+//  # comment
+//  top_level_key = value
+//  [section]
+//  key = value
+//  key: value
+//  quoted = "value with leading or trailing spaces "
+//  [section.subsection]
+//  key = value
+// Sections become nested maps; dots in section names create deeper nesting. Keys before
+// the first section are top-level. Later values for the same key replace earlier ones.
+//
+// INI values are untyped strings. They are converted to the types of the corresponding
+// result struct fields (see configloader.UntypedCodec and the untyped package). Slice
+// values are comma-separated.
+package ini
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/untyped"
+	"github.com/pkg/errors"
+)
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation. It also implements configloader.UntypedCodec.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	m, err := untyped.ToMap(v, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	// Top-level keys have to come before any sections
+	for _, k := range untyped.SortedKeys(m) {
+		if s, ok := m[k].(string); ok {
+			fmt.Fprintf(&buf, "%s = %s\n", k, quoteIfNeeded(s))
+		}
+	}
+
+	for _, k := range untyped.SortedKeys(m) {
+		if section, ok := m[k].(map[string]interface{}); ok {
+			if err := marshalSection(&buf, k, section); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func marshalSection(buf *bytes.Buffer, name string, section map[string]interface{}) error {
+	if strings.ContainsAny(name, "[]\n") {
+		return errors.Errorf("invalid INI section name: %q", name)
+	}
+
+	fmt.Fprintf(buf, "\n[%s]\n", name)
+	for _, k := range untyped.SortedKeys(section) {
+		if s, ok := section[k].(string); ok {
+			fmt.Fprintf(buf, "%s = %s\n", k, quoteIfNeeded(s))
+		}
+	}
+
+	for _, k := range untyped.SortedKeys(section) {
+		if subsection, ok := section[k].(map[string]interface{}); ok {
+			if err := marshalSection(buf, name+"."+k, subsection); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// quoteIfNeeded quotes s if it wouldn't otherwise survive a round trip.
+func quoteIfNeeded(s string) string {
+	if s != strings.TrimSpace(s) || strings.ContainsAny(s, "\"\n;#") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	parsed, err := parse(data)
+	if err != nil {
+		return err
+	}
+
+	if m, ok := v.(*map[string]interface{}); ok {
+		if *m == nil {
+			*m = make(map[string]interface{})
+		}
+		for k, val := range parsed {
+			(*m)[k] = val
+		}
+		return nil
+	}
+
+	return untyped.Decode(parsed, v, codec)
+}
+
+// parse parses INI data into nested maps of strings.
+func parse(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	currSection := result
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, errors.Errorf("line %d: malformed section header: %s", lineNum, line)
+			}
+
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, errors.Errorf("line %d: empty section name", lineNum)
+			}
+
+			currSection = result
+			for _, part := range strings.Split(name, ".") {
+				part = strings.TrimSpace(part)
+				sub, exists := currSection[part]
+				if !exists {
+					sub = make(map[string]interface{})
+					currSection[part] = sub
+				}
+				subMap, ok := sub.(map[string]interface{})
+				if !ok {
+					return nil, errors.Errorf("line %d: section %s conflicts with key %s", lineNum, name, part)
+				}
+				currSection = subMap
+			}
+			continue
+		}
+
+		sep := strings.IndexAny(line, "=:")
+		if sep < 0 {
+			return nil, errors.Errorf("line %d: expected key = value: %s", lineNum, line)
+		}
+
+		key := strings.TrimSpace(line[:sep])
+		if key == "" {
+			return nil, errors.Errorf("line %d: empty key", lineNum)
+		}
+
+		val := strings.TrimSpace(line[sep+1:])
+		if strings.HasPrefix(val, `"`) {
+			unquoted, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d: bad quoted value: %s", lineNum, val)
+			}
+			val = unquoted
+		}
+
+		if _, isSection := currSection[key].(map[string]interface{}); isSection {
+			return nil, errors.Errorf("line %d: key %s conflicts with section", lineNum, key)
+		}
+		currSection[key] = val
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("ini") == "-"
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	if codec.IsStructFieldIgnored(st) {
+		return ""
+	}
+
+	if typeTag := st.Get("ini"); typeTag != "" {
+		return strings.Split(typeTag, ",")[0]
+	}
+
+	return ""
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	return untyped.FieldTypesConsistent(check, gold)
+}
+
+// ConvertString implements configloader.UntypedCodec.
+func (codec codecImplmentation) ConvertString(s string, field *reflection.StructField) (interface{}, error) {
+	return untyped.ConvertString(s, field)
+}
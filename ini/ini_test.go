@@ -0,0 +1,193 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package ini_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/ini"
+)
+
+func TestLoad(t *testing.T) {
+	type config struct {
+		Name   string `ini:"name"`
+		Server struct {
+			ListenPort uint16 `ini:"listen_port"`
+			Hostname   string
+			Timeout    time.Duration `ini:"timeout"`
+			Debug      bool          `ini:"debug" conf:"optional"`
+		} `ini:"server"`
+		Limits struct {
+			Ratio   float32  `ini:"ratio"`
+			Offsets []int    `ini:"offsets"`
+			Tags    []string `ini:"tags"`
+			Nested  struct {
+				Depth int `ini:"depth"`
+			} `ini:"nested"`
+		} `ini:"limits"`
+		Started time.Time         `ini:"started"`
+		Labels  map[string]int    `ini:"labels" conf:"optional"`
+		Ignored string            `ini:"-"`
+		Extra   map[string]string `conf:"optional"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+; top-level keys come before sections
+name = "  spaced  "
+
+[server]
+listen_port = 80
+HostName: example.com
+timeout = 5s
+
+[limits]
+ratio = 0.5
+offsets = 1, -2, 3
+tags = a, b
+
+[limits.nested]
+depth = 3
+
+[labels]
+a = 1
+`),
+		strings.NewReader(`
+# the override
+started = 2019-01-02T03:04:05Z
+
+[Server]
+; leading zeros are decimal, not octal
+listen_port = 08080
+debug = true
+
+[labels]
+b = 2
+`),
+	}
+
+	var result config
+	md, err := configloader.Load(ini.Codec, readers, []string{"config.ini", "override.ini"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Name = "  spaced  "
+	want.Server.ListenPort = 8080
+	want.Server.Hostname = "example.com"
+	want.Server.Timeout = 5 * time.Second
+	want.Server.Debug = true
+	want.Limits.Ratio = 0.5
+	want.Limits.Offsets = []int{1, -2, 3}
+	want.Limits.Tags = []string{"a", "b"}
+	want.Limits.Nested.Depth = 3
+	want.Started = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	want.Labels = map[string]int{"a": 1, "b": 2}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	wantProvs := map[string]string{
+		"name":                "config.ini",
+		"server.listen_port":  "override.ini",
+		"server.Hostname":     "config.ini",
+		"server.timeout":      "config.ini",
+		"server.debug":        "override.ini",
+		"limits.ratio":        "config.ini",
+		"limits.offsets":      "config.ini",
+		"limits.tags":         "config.ini",
+		"limits.nested.depth": "config.ini",
+		"started":             "override.ini",
+		"labels.a":            "config.ini",
+		"labels.b":            "override.ini",
+		"Extra":               "[absent]",
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		if wantProvs[prov.Key.String()] != prov.Src {
+			t.Fatalf("provenance mismatch for %v;\ngot  %v\nwant %v", prov.Key, md.Provenances, wantProvs)
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	type config struct {
+		A int    `ini:"a"`
+		B string `ini:"-" conf:"optional"`
+		C struct {
+			D uint8 `ini:"d"`
+		} `ini:"c" conf:"optional"`
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"vestigial field", "a = 1\nz = 2\n"},
+		{"vestigial section", "a = 1\n[z]\ny = 1\n"},
+		{"ignored field", "a = 1\nb = x\n"},
+		{"type mismatch", "a = abc\n"},
+		{"out of range", "a = 1\n[c]\nd = 300\n"},
+		{"missing required", "\n"},
+		{"bad section", "[c\n"},
+		{"no separator", "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := configloader.Load(ini.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, nil, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	m := map[string]interface{}{
+		"b": "bee",
+		"a": int64(1),
+		"s": map[string]interface{}{
+			"x":   []interface{}{int64(1), int64(2)},
+			"sub": map[string]interface{}{"y": " padded"},
+		},
+	}
+
+	got, err := ini.Codec.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := "a = 1\nb = bee\n\n[s]\nx = 1, 2\n\n[s.sub]\ny = \" padded\"\n"
+	if string(got) != want {
+		t.Fatalf("Marshal mismatch;\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	var roundTrip map[string]interface{}
+	if err := ini.Codec.Unmarshal(got, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	wantRoundTrip := map[string]interface{}{
+		"a": "1",
+		"b": "bee",
+		"s": map[string]interface{}{
+			"x":   "1, 2",
+			"sub": map[string]interface{}{"y": " padded"},
+		},
+	}
+	if !reflect.DeepEqual(roundTrip, wantRoundTrip) {
+		t.Fatalf("round trip mismatch;\ngot  %#v\nwant %#v", roundTrip, wantRoundTrip)
+	}
+}
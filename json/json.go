@@ -5,11 +5,19 @@
  */
 
 // Package json provides JSON Codec methods for use with configloader.
+//
+// When unmarshaling into a map, JSON numbers are decoded as int64 if they are integers
+// (or uint64, if they are too big for int64), and as float64 otherwise. This preserves
+// the precision of large integers, which float64 can't represent exactly.
 package json
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/Psiphon-Inc/configloader-go/reflection"
@@ -18,7 +26,8 @@ import (
 
 type codecImplmentation struct{}
 
-// Codec is the configloader.Codec implementation.
+// Codec is the configloader.Codec implementation. It also implements
+// configloader.PositionCodec.
 var Codec = codecImplmentation{}
 
 func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
@@ -26,7 +35,81 @@ func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
 }
 
 func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
-	return json.Unmarshal(data, v)
+	if _, ok := v.(*map[string]interface{}); !ok {
+		// encoding/json already does the right thing with typed fields
+		return json.Unmarshal(data, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	// Make sure there's nothing after the value, like json.Unmarshal does
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level JSON value")
+	}
+
+	m := v.(*map[string]interface{})
+	for k := range *m {
+		val, err := convertNumbers((*m)[k])
+		if err != nil {
+			return errors.Wrapf(err, "bad number at key %s", k)
+		}
+		(*m)[k] = val
+	}
+
+	return nil
+}
+
+// convertNumbers replaces the json.Number values in v (recursively) with int64, uint64
+// or float64 values.
+func convertNumbers(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return convertNumber(v)
+
+	case map[string]interface{}:
+		for k := range v {
+			val, err := convertNumbers(v[k])
+			if err != nil {
+				return nil, err
+			}
+			v[k] = val
+		}
+
+	case []interface{}:
+		for i := range v {
+			val, err := convertNumbers(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+	}
+
+	return v, nil
+}
+
+func convertNumber(n json.Number) (interface{}, error) {
+	s := string(n)
+
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u, nil
+		}
+		// Too big for any integer type; fall through to float
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
 // Returns true if the struct tag indicates that the field should not be inspected
@@ -48,9 +131,91 @@ func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string
 }
 
 func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
-	if strings.HasPrefix(check.Kind, "float") && (strings.HasPrefix(gold.Kind, "float") || strings.HasPrefix(gold.Kind, "int")) {
+	// JSON doesn't distinguish between number types, so any number may be used for any
+	// numeric field, including a number written like a float (such as 8080.0 or 1e3) for
+	// an integer field. Values that don't fit, like 300 for a uint8 or 1.5 for an int,
+	// are rejected by Load's range checks.
+	isNumber := func(kind string) bool {
+		return strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint") || strings.HasPrefix(kind, "float")
+	}
+	if isNumber(check.Kind) && isNumber(gold.Kind) {
 		return true, nil
 	}
 
 	return false, errors.Errorf("field types inconsistent")
 }
+
+// KeyPositions implements configloader.PositionCodec. Keys of objects within arrays are
+// not reported.
+func (codec codecImplmentation) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	var positions []reflection.KeyPosition
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	// Line starting offsets, for converting offsets to lines and columns
+	lineStarts := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+
+	var walk func(path []string, record bool) error
+	walk = func(path []string, record bool) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				// The offset is after the previous token; the key starts at the next quote
+				offset := int(dec.InputOffset())
+				if i := bytes.IndexByte(data[offset:], '"'); i >= 0 {
+					offset += i
+				}
+
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ := keyTok.(string)
+				keyPath := append(append([]string{}, path...), key)
+
+				if record {
+					line := sort.Search(len(lineStarts), func(i int) bool { return lineStarts[i] > offset })
+					positions = append(positions, reflection.KeyPosition{
+						Key:    keyPath,
+						Line:   line,
+						Column: offset - lineStarts[line-1] + 1,
+					})
+				}
+
+				if err := walk(keyPath, record); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token() // '}'
+			return err
+
+		case json.Delim('['):
+			for dec.More() {
+				if err := walk(path, false); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token() // ']'
+			return err
+		}
+
+		return nil
+	}
+
+	if err := walk(nil, true); err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,166 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package jsonc provides Codec methods for use with configloader for JSON with comments
+// and trailing commas (often called JSONC), like:
+//  {
+//    // The port to listen on
+//    "listen_port": 8080,
+//    /* Multi-line
+//       comment */
+//    "hosts": [
+//      "a.example.com",
+//      "b.example.com", // trailing commas are fine
+//    ],
+//  }
+// Otherwise it behaves exactly like the json package, including the use of `json:`
+// struct tags. (Other JSON5 extensions, like unquoted keys, are not supported.)
+//
+// Marshaling produces standard JSON.
+package jsonc
+
+import (
+	"reflect"
+
+	"github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+)
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation. It also implements
+// configloader.PositionCodec.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	return json.Codec.Marshal(v)
+}
+
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	std, err := Standardize(data)
+	if err != nil {
+		return err
+	}
+	return json.Codec.Unmarshal(std, v)
+}
+
+// Standardize converts JSONC data into standard JSON by replacing comments and trailing
+// commas with whitespace. Line breaks are kept, so line and column numbers in the result
+// match those in data.
+func Standardize(data []byte) ([]byte, error) {
+	std := make([]byte, len(data))
+	copy(std, data)
+
+	// First blank out the comments
+	inString := false
+	for i := 0; i < len(std); i++ {
+		c := std[i]
+
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		if c != '/' || i+1 == len(std) {
+			continue
+		}
+
+		switch std[i+1] {
+		case '/':
+			for ; i < len(std) && std[i] != '\n'; i++ {
+				std[i] = ' '
+			}
+		case '*':
+			start := i
+			std[i], std[i+1] = ' ', ' '
+			for i += 2; ; i++ {
+				if i+1 >= len(std) {
+					return nil, errors.Errorf("unterminated comment starting at offset %d", start)
+				}
+				if std[i] == '*' && std[i+1] == '/' {
+					std[i], std[i+1] = ' ', ' '
+					i++
+					break
+				}
+				if std[i] != '\n' && std[i] != '\r' {
+					std[i] = ' '
+				}
+			}
+		}
+	}
+
+	// Then the trailing commas
+	inString = false
+	for i := 0; i < len(std); i++ {
+		c := std[i]
+
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		if c != ',' {
+			continue
+		}
+
+		j := i + 1
+		for j < len(std) && isSpace(std[j]) {
+			j++
+		}
+		if j < len(std) && (std[j] == '}' || std[j] == ']') {
+			std[i] = ' '
+		}
+	}
+
+	return std, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return json.Codec.IsStructFieldIgnored(st)
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	return json.Codec.GetStructFieldAlias(st)
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	return json.Codec.FieldTypesConsistent(check, gold)
+}
+
+// KeyPositions implements configloader.PositionCodec.
+func (codec codecImplmentation) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	// Standardize doesn't move anything, so the positions are the same
+	std, err := Standardize(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Codec.KeyPositions(std)
+}
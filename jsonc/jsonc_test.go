@@ -0,0 +1,108 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package jsonc_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/jsonc"
+)
+
+func TestStandardize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", `{"a": [1, 2]}`, `{"a": [1, 2]}`, false},
+		{"line comment", "{\"a\": 1 // c\n}", "{\"a\": 1     \n}", false},
+		{"block comment", "{/* x\ny */\"a\": 1}", "{    \n    \"a\": 1}", false},
+		{"comment markers in string", `{"a": "// /* , }"}`, `{"a": "// /* , }"}`, false},
+		{"escaped quote in string", `{"a": "\" // x"}`, `{"a": "\" // x"}`, false},
+		{"trailing commas", "{\"a\": [1, 2,],\n}", "{\"a\": [1, 2 ] \n}", false},
+		{"trailing comma before comment", "[1, // c\n]", "[1      \n]", false},
+		{"unterminated comment", "{/* x", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := jsonc.Standardize([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Standardize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Fatalf("Standardize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	type config struct {
+		ListenPort int      `json:"listen_port"`
+		Ratio      float64  `json:"ratio"`
+		Hosts      []string `json:"hosts"`
+		Ignored    string   `json:"-"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`{
+  // The port to listen on
+  "listen_port": 80,
+  /* Multi-line
+     comment */
+  "ratio": 1,
+  "hosts": [
+    "a.example.com",
+    "b.example.com", // trailing comma
+  ],
+}`),
+		// An integer may be written like a float
+		strings.NewReader(`{"listen_port": 8080.0, /* override */}`),
+	}
+
+	var result config
+	md, err := configloader.Load(jsonc.Codec, readers, []string{"config.jsonc", "override.jsonc"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := config{
+		ListenPort: 8080,
+		Ratio:      1,
+		Hosts:      []string{"a.example.com", "b.example.com"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	wantProvs := map[string]string{
+		"listen_port": "override.jsonc",
+		"ratio":       "config.jsonc",
+		"hosts":       "config.jsonc",
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		if wantProvs[prov.Key.String()] != prov.Src {
+			t.Fatalf("provenance mismatch for %v;\ngot  %v\nwant %v", prov.Key, md.Provenances, wantProvs)
+		}
+	}
+
+	// Vestigial and ignored fields are still detected
+	for _, doc := range []string{`{"nope": 1, /* c */}`, `{"Ignored": "x"}`} {
+		_, err = configloader.Load(jsonc.Codec, []io.Reader{strings.NewReader(doc)}, nil, nil, nil, &result)
+		if err == nil {
+			t.Fatalf("Load should have failed for %s", doc)
+		}
+	}
+}
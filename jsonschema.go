@@ -0,0 +1,410 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateTagName is used in struct tags like `validate:"min=1,max=65535"` to give the
+// validation rules that JSONSchema() includes in its output. The rules are those of
+// validation libraries like github.com/go-playground/validator (which can be used to
+// enforce them after loading); configloader itself doesn't enforce them. Can be modified
+// if the caller desires.
+var ValidateTagName = "validate"
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+/*
+JSONSchema returns a JSON Schema (draft 2020-12) for config files for the struct result,
+which may be a struct or a pointer to one. It can be used to validate config files in
+editors and CI.
+
+The property names are codec's aliases. The schema matches what Load() checks:
+  - Fields that aren't optional (see Describe) are required.
+  - Struct fields have "additionalProperties": false, as unknown ("vestigial") fields
+    are errors. (Structs within maps don't, as Load doesn't check inside maps.)
+  - Fields that implement encoding.TextUnmarshaler are strings, as are fields with an
+    explicit type of "string".
+  - Integer fields are limited to the range of their type.
+
+Defaults (from WithDefaults in opts) are included, as are doc texts (see DocTagName) as
+descriptions, and enums and ranges from the ValidateTagName struct tags (min, max, gte,
+lte, gt, lt, len, and oneof rules; others are ignored). Rules after "dive" apply to the
+elements of slices, arrays, and maps (rules for map keys are ignored), rules with
+alternatives (like "min=5|eq=0") are ignored, and with "omitempty", empty values are
+allowed as well as those that match the other rules.
+*/
+func JSONSchema(result interface{}, codec Codec, opts ...LoadOption) ([]byte, error) {
+	if codec == nil {
+		return nil, errors.New("codec must not be nil")
+	}
+
+	resultType := reflect.TypeOf(result)
+	for resultType != nil && resultType.Kind() == reflect.Ptr {
+		resultType = resultType.Elem()
+	}
+	if resultType == nil || resultType.Kind() != reflect.Struct {
+		return nil, errors.Errorf("result must be a struct or pointer to struct; got %T", result)
+	}
+
+	g := jsonSchemaGenerator{codec}
+	schema := Describe(result, append(opts, WithCodec(codec))...)
+	root, err := g.objectSchema(schema.Fields, true)
+	if err != nil {
+		return nil, err
+	}
+	root["$schema"] = jsonSchemaDraft
+
+	b, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "json.MarshalIndent failed")
+	}
+	return b, nil
+}
+
+// jsonSchemaGenerator holds the codec used by a call to JSONSchema
+type jsonSchemaGenerator struct {
+	codec Codec
+}
+
+// objectSchema returns the schema of an object with the given fields. If strict is true,
+// required fields and unknown fields are checked for.
+func (g jsonSchemaGenerator) objectSchema(fields []*SchemaField, strict bool) (map[string]interface{}, error) {
+	properties := make(map[string]interface{}, len(fields))
+	var required []string
+	for _, f := range fields {
+		name := f.Key[len(f.Key)-1]
+
+		fieldSchema, err := g.fieldSchema(f, strict)
+		if err != nil {
+			return nil, err
+		}
+		properties[name] = fieldSchema
+
+		if !f.Optional {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if strict {
+		s["additionalProperties"] = false
+		if len(required) > 0 {
+			s["required"] = required
+		}
+	}
+	return s, nil
+}
+
+// fieldSchema returns the schema of the struct field f. strict is as for objectSchema.
+func (g jsonSchemaGenerator) fieldSchema(f *SchemaField, strict bool) (map[string]interface{}, error) {
+	var s map[string]interface{}
+	var err error
+
+	fieldType := f.Type
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	switch {
+	case f.ExpectedType != "":
+		s = map[string]interface{}{}
+		if jsonType := jsonTypeForGoType(f.ExpectedType); jsonType != "" {
+			s["type"] = jsonType
+		}
+	case fieldType.Kind() == reflect.Struct:
+		s, err = g.objectSchema(f.Children, strict)
+	default:
+		s, err = g.typeSchema(fieldType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyValidateRules(s, f.Tag.Get(ValidateTagName), fieldType); err != nil {
+		return nil, &FieldError{Key: f.Key, Err: err}
+	}
+
+	if f.HasDefault {
+		s["default"] = f.Default
+	}
+	if f.Doc != "" {
+		s["description"] = f.Doc
+	}
+
+	return s, nil
+}
+
+// typeSchema returns the schema of values of type t, for those that aren't struct fields
+// (like map and slice elements).
+func (g jsonSchemaGenerator) typeSchema(t reflect.Type) (map[string]interface{}, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return map[string]interface{}{"type": "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.typeSchema(t.Elem())
+
+	case reflect.Struct:
+		return g.objectSchema(Describe(reflect.New(t).Interface(), WithCodec(g.codec)).Fields, false)
+
+	case reflect.Map:
+		elemSchema, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": elemSchema}, nil
+
+	case reflect.Slice, reflect.Array:
+		elemSchema, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		s := map[string]interface{}{"type": "array", "items": elemSchema}
+		if t.Kind() == reflect.Array {
+			s["maxItems"] = t.Len()
+		}
+		return s, nil
+	}
+
+	s := map[string]interface{}{}
+	if jsonType := jsonTypeForGoType(t.Kind().String()); jsonType != "" {
+		s["type"] = jsonType
+	}
+
+	// Limit integers to the range of their type, as Load does (but leave out the limits of
+	// int64, which are implied). Unsigned types all have a maximum, as uint64's is beyond
+	// that of int64.
+	if limits, ok := numericRanges[t.Kind().String()]; ok && s["type"] == "integer" {
+		if limits.min > math.MinInt64 {
+			s["minimum"] = limits.min
+		}
+		if limits.max != math.MaxInt64 {
+			s["maximum"] = limits.max
+		}
+	}
+
+	return s, nil
+}
+
+// jsonTypeForGoType returns the JSON Schema type for the Go type or kind named goType
+// (like "uint8"), or "" if there isn't a single one.
+func jsonTypeForGoType(goType string) string {
+	switch goType {
+	case "bool":
+		return "boolean"
+	case "string":
+		return "string"
+	case "float32", "float64":
+		return "number"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "integer"
+	}
+	return ""
+}
+
+// applyValidateRules adds the schema keywords for the rules in tag (the ValidateTagName
+// tag of a field of type t) to s. Rules after "dive" apply to the elements of a slice,
+// array, or map, so they are added to the schema of the elements.
+func applyValidateRules(s map[string]interface{}, tag string, t reflect.Type) error {
+	if tag == "" {
+		return nil
+	}
+
+	rules := strings.Split(tag, ",")
+	var diveRules []string
+	for i, rule := range rules {
+		if rule == "dive" {
+			rules, diveRules = rules[:i], rules[i+1:]
+			break
+		}
+	}
+
+	if err := applyRules(s, rules, t); err != nil {
+		return err
+	}
+
+	if diveRules == nil {
+		return nil
+	}
+
+	// The element schema is "items" for slices and arrays and "additionalProperties" for
+	// maps (see typeSchema)
+	var elemSchema interface{}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		elemSchema = s["items"]
+	case reflect.Map:
+		elemSchema = s["additionalProperties"]
+	}
+	elemMap, ok := elemSchema.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("dive rule only supported for slices, arrays, and maps; got %s", t)
+	}
+
+	elemType := t.Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+
+	// Skip the rules for map keys (between "keys" and "endkeys")
+	if len(diveRules) > 0 && diveRules[0] == "keys" {
+		end := len(diveRules)
+		for i, rule := range diveRules {
+			if rule == "endkeys" {
+				end = i + 1
+				break
+			}
+		}
+		diveRules = diveRules[end:]
+	}
+
+	return applyValidateRules(elemMap, strings.Join(diveRules, ","), elemType)
+}
+
+// applyRules adds the schema keywords for rules (which don't include "dive") to s, which
+// is the schema of type t. If the rules include "omitempty", the rules only apply if the
+// value isn't empty.
+func applyRules(s map[string]interface{}, rules []string, t reflect.Type) error {
+	// The keywords for min, max, and len depend on the type
+	var minKeyword, maxKeyword string
+	numeric := false
+	switch s["type"] {
+	case "integer", "number":
+		minKeyword, maxKeyword, numeric = "minimum", "maximum", true
+	case "string":
+		minKeyword, maxKeyword = "minLength", "maxLength"
+	case "array":
+		minKeyword, maxKeyword = "minItems", "maxItems"
+	case "object":
+		minKeyword, maxKeyword = "minProperties", "maxProperties"
+	}
+
+	ruleSchema := make(map[string]interface{})
+	omitEmpty := false
+
+	for _, rule := range rules {
+		name, value := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, value = rule[:i], rule[i+1:]
+		}
+
+		if strings.Contains(rule, "|") {
+			// Alternatives (like "min=5|eq=0") can't be expressed with the keywords of a
+			// single schema
+			continue
+		}
+
+		var keywords []string
+		switch name {
+		case "omitempty":
+			omitEmpty = true
+			continue
+		case "min", "gte":
+			keywords = []string{minKeyword}
+		case "max", "lte":
+			keywords = []string{maxKeyword}
+		case "gt", "lt":
+			if !numeric {
+				return errors.Errorf("%s rule only supported for numbers; got %s", name, t)
+			}
+			keywords = []string{map[string]string{"gt": "exclusiveMinimum", "lt": "exclusiveMaximum"}[name]}
+		case "len":
+			if numeric {
+				return errors.Errorf("len rule not supported for numbers; got %s", t)
+			}
+			keywords = []string{minKeyword, maxKeyword}
+		case "oneof":
+			enum := make([]interface{}, 0)
+			for _, v := range strings.Fields(value) {
+				enumVal, err := ruleValue(v, numeric)
+				if err != nil {
+					return errors.Wrapf(err, "bad oneof rule value")
+				}
+				enum = append(enum, enumVal)
+			}
+			ruleSchema["enum"] = enum
+			continue
+		default:
+			// Not a rule that can be expressed in the schema (like "required")
+			continue
+		}
+
+		if keywords[0] == "" {
+			return errors.Errorf("%s rule not supported for %s", name, t)
+		}
+
+		// Lengths and counts must be non-negative integers
+		val, err := ruleValue(value, true)
+		if err == nil && !numeric {
+			_, err = strconv.ParseUint(value, 10, 0)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "bad %s rule value", name)
+		}
+
+		for _, kw := range keywords {
+			ruleSchema[kw] = val
+		}
+	}
+
+	if len(ruleSchema) == 0 {
+		return nil
+	}
+
+	if empty := emptySchema(s["type"]); omitEmpty && empty != nil {
+		s["anyOf"] = []interface{}{empty, ruleSchema}
+		return nil
+	}
+
+	for kw, val := range ruleSchema {
+		s[kw] = val
+	}
+	return nil
+}
+
+// emptySchema returns a schema that matches only the empty value of the JSON type
+// jsonType (the value that the "omitempty" rule exempts from the other rules), or nil if
+// there isn't one.
+func emptySchema(jsonType interface{}) map[string]interface{} {
+	switch jsonType {
+	case "string":
+		return map[string]interface{}{"const": ""}
+	case "integer", "number":
+		return map[string]interface{}{"const": 0}
+	case "boolean":
+		return map[string]interface{}{"const": false}
+	case "array":
+		return map[string]interface{}{"maxItems": 0}
+	case "object":
+		return map[string]interface{}{"maxProperties": 0}
+	}
+	return nil
+}
+
+// ruleValue converts the rule value v into the value used in the schema. If numeric is
+// true, v must be a number, and it is kept as written.
+func ruleValue(v string, numeric bool) (interface{}, error) {
+	if !numeric {
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(v, 64); err != nil {
+		return nil, errors.Errorf("not a number: %q", v)
+	}
+	return json.Number(v), nil
+}
@@ -0,0 +1,260 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	configjson "github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func TestJSONSchema(t *testing.T) {
+	type backend struct {
+		URL    string `toml:"url"`
+		Weight uint8  `toml:"weight"`
+	}
+	type config struct {
+		Server struct {
+			Host string `toml:"host" doc:"The host to listen on"`
+			Port uint16 `toml:"port" validate:"min=1"`
+		} `toml:"server"`
+		Level    string             `toml:"level" conf:"optional" validate:"required,oneof=debug info"`
+		Ratio    float64            `toml:"ratio" conf:"optional" validate:"gt=0,lte=1.5"`
+		Timeout  time.Duration      `toml:"timeout" conf:"optional,string"`
+		Start    time.Time          `toml:"start" conf:"optional"`
+		Hosts    []string           `toml:"hosts" conf:"optional" validate:"min=1,dive,max=10"`
+		Backends map[string]backend `toml:"backends" conf:"optional"`
+		Limits   map[string]uint8   `toml:"limits" conf:"optional" validate:"dive,keys,min=2,endkeys,max=100"`
+		Count    int                `toml:"count" conf:"optional"`
+		Name     string             `toml:"name" conf:"optional" validate:"omitempty,min=3"`
+		Mode     string             `toml:"mode" conf:"optional" validate:"min=5|eq=0"`
+		Size     uint64             `toml:"size" conf:"optional"`
+		Ignored  string             `toml:"-"`
+	}
+
+	got, err := JSONSchema(&config{}, toml.Codec,
+		WithDefaults(Default{Key: Key{"Count"}, Val: 3}))
+	if err != nil {
+		t.Fatalf("JSONSchema failed: %v", err)
+	}
+
+	want := `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "additionalProperties": false,
+  "required": ["server"],
+  "properties": {
+    "server": {
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["host", "port"],
+      "properties": {
+        "host": {"type": "string", "description": "The host to listen on"},
+        "port": {"type": "integer", "minimum": 1, "maximum": 65535}
+      }
+    },
+    "level": {"type": "string", "enum": ["debug", "info"]},
+    "ratio": {"type": "number", "exclusiveMinimum": 0, "maximum": 1.5},
+    "timeout": {"type": "string"},
+    "start": {"type": "string"},
+    "hosts": {"type": "array", "items": {"type": "string", "maxLength": 10}, "minItems": 1},
+    "backends": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "properties": {
+          "url": {"type": "string"},
+          "weight": {"type": "integer", "minimum": 0, "maximum": 255}
+        }
+      }
+    },
+    "limits": {
+      "type": "object",
+      "additionalProperties": {"type": "integer", "minimum": 0, "maximum": 100}
+    },
+    "count": {"type": "integer", "default": 3},
+    "name": {"type": "string", "anyOf": [{"const": ""}, {"minLength": 3}]},
+    "mode": {"type": "string"},
+    "size": {"type": "integer", "minimum": 0, "maximum": 18446744073709551615}
+  }
+}`
+
+	var gotVal, wantVal interface{}
+	if err := json.Unmarshal(got, &gotVal); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantVal); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotVal, wantVal) {
+		t.Fatalf("schema mismatch; got:\n%s", got)
+	}
+
+	// Check the exact value, which doesn't survive the round trip through float64
+	if !strings.Contains(string(got), `"maximum": 18446744073709551615`) {
+		t.Fatalf("expected uint64 maximum; got:\n%s", got)
+	}
+}
+
+func TestJSONSchema_NestedDefaults(t *testing.T) {
+	type config struct {
+		Name   string `json:"name"`
+		Server struct {
+			Port int `json:"port"`
+			TLS  struct {
+				Cert string `json:"cert"`
+			} `json:"tls"`
+		} `json:"server"`
+		DB struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"db"`
+	}
+	defaults := []Default{
+		{Key: Key{"Server", "Port"}, Val: 8080},
+		{Key: Key{"Server", "TLS", "Cert"}, Val: "cert.pem"},
+		{Key: Key{"DB", "Port"}, Val: 5432},
+	}
+
+	b, err := JSONSchema(&config{}, configjson.Codec, WithDefaults(defaults...))
+	if err != nil {
+		t.Fatalf("JSONSchema failed: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, b)
+	}
+
+	// The schema and Load must agree about which configs are complete. (Only required
+	// properties differ between these configs, so that's all that's checked.)
+	tests := []struct {
+		doc  string
+		want bool
+	}{
+		// server is supplied entirely by defaults, but db.host has none
+		{`{"name": "x", "db": {"host": "h"}}`, true},
+		{`{"name": "x", "server": {"tls": {}}, "db": {"host": "h", "port": 1}}`, true},
+		{`{"name": "x"}`, false},
+		{`{"name": "x", "db": {"port": 1}}`, false},
+		{`{"db": {"host": "h"}}`, false},
+	}
+	for _, tt := range tests {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		missing := missingRequired(schema, doc)
+		if (len(missing) == 0) != tt.want {
+			t.Fatalf("schema validity of %s mismatch; missing %v", tt.doc, missing)
+		}
+
+		var result config
+		_, err := Load(configjson.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, defaults, nil, &result)
+		if (err == nil) != tt.want {
+			t.Fatalf("Load validity of %s mismatch; err %v", tt.doc, err)
+		}
+	}
+}
+
+// missingRequired returns the required properties of schema (as generated by JSONSchema)
+// that are missing from doc, recursively.
+func missingRequired(schema, doc map[string]interface{}) []string {
+	var missing []string
+	required, _ := schema["required"].([]interface{})
+	for _, r := range required {
+		if _, ok := doc[r.(string)]; !ok {
+			missing = append(missing, r.(string))
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, v := range doc {
+		propSchema, _ := properties[name].(map[string]interface{})
+		if subDoc, ok := v.(map[string]interface{}); ok && propSchema != nil {
+			for _, m := range missingRequired(propSchema, subDoc) {
+				missing = append(missing, name+"."+m)
+			}
+		}
+	}
+	return missing
+}
+
+func TestJSONSchema_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  interface{}
+		codec   Codec
+		wantErr string
+	}{
+		{
+			name:    "nil codec",
+			result:  &struct{}{},
+			wantErr: "codec must not be nil",
+		},
+		{
+			name:    "not a struct",
+			result:  map[string]interface{}{},
+			codec:   toml.Codec,
+			wantErr: "result must be a struct",
+		},
+		{
+			name: "bad number",
+			result: &struct {
+				N int `validate:"max=lots"`
+			}{},
+			codec:   toml.Codec,
+			wantErr: "bad max rule value",
+		},
+		{
+			name: "bad length",
+			result: &struct {
+				S string `validate:"len=-1"`
+			}{},
+			codec:   toml.Codec,
+			wantErr: "bad len rule value",
+		},
+		{
+			name: "unsupported rule",
+			result: &struct {
+				S string `validate:"gt=1"`
+			}{},
+			codec:   toml.Codec,
+			wantErr: "gt rule only supported for numbers",
+		},
+		{
+			name: "dive into non-collection",
+			result: &struct {
+				S string `validate:"dive,min=1"`
+			}{},
+			codec:   toml.Codec,
+			wantErr: "dive rule only supported for slices, arrays, and maps",
+		},
+		{
+			name: "bad rule after dive",
+			result: &struct {
+				S []int `validate:"dive,len=1"`
+			}{},
+			codec:   toml.Codec,
+			wantErr: "len rule not supported for numbers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := JSONSchema(tt.result, tt.codec)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+// makeBenchStruct returns a pointer to a new struct with n string fields, each with a toml
+// alias, and a matching pair of TOML documents: the first sets every field, and the second
+// overrides every tenth.
+func makeBenchStruct(n int) (result interface{}, docs []string) {
+	return makeBenchStructOf(n, reflect.TypeOf(""), `"base"`, `"override"`)
+}
+
+// makeBenchStructOf is like makeBenchStruct, with fields of type t, and the given TOML
+// values for the base and override documents.
+func makeBenchStructOf(n int, t reflect.Type, baseVal, overrideVal string) (result interface{}, docs []string) {
+	fields := make([]reflect.StructField, n)
+	var base, override strings.Builder
+	for i := range fields {
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`toml:"field_%d"`, i)),
+		}
+		fmt.Fprintf(&base, "field_%d = %s\n", i, baseVal)
+		if i%10 == 0 {
+			fmt.Fprintf(&override, "field_%d = %s\n", i, overrideVal)
+		}
+	}
+	return reflect.New(reflect.StructOf(fields)).Interface(), []string{base.String(), override.String()}
+}
+
+// makeBenchRoutes returns TOML documents that populate a map of n structs within a struct,
+// like a large routing table: the first sets every entry, and the second overrides every
+// tenth and adds n/10 more.
+func makeBenchRoutes(n int) []string {
+	var base, override strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&base, "[routes.r%d]\nupstream = \"u%d\"\nweight = %d\n", i, i, i)
+		if i%10 == 0 {
+			fmt.Fprintf(&override, "[routes.r%d]\nweight = 0\n[routes.extra%d]\nupstream = \"x\"\n", i, i)
+		}
+	}
+	return []string{base.String(), override.String()}
+}
+
+type benchRoutesConfig struct {
+	Routes map[string]struct {
+		Upstream string `toml:"upstream"`
+		Weight   int    `toml:"weight" conf:"optional"`
+	} `toml:"routes"`
+}
+
+func BenchmarkLoad_Fields(b *testing.B) {
+	for _, n := range []int{100, 500, 2000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			result, docs := makeBenchStruct(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Load(toml.Codec, benchReaders(docs), nil, nil, nil, result); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// Slice fields aren't checked beyond the slice itself, so each is a prefix that the
+// remaining fields are checked against.
+func BenchmarkLoad_SliceFields(b *testing.B) {
+	for _, n := range []int{100, 500, 2000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			result, docs := makeBenchStructOf(n, reflect.TypeOf([]string{}), `["a", "b"]`, `["c"]`)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Load(toml.Codec, benchReaders(docs), nil, nil, nil, result); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoad_MapWithinStruct(b *testing.B) {
+	for _, n := range []int{100, 500, 2000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			docs := makeBenchRoutes(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var result benchRoutesConfig
+				if _, err := Load(toml.Codec, benchReaders(docs), nil, nil, nil, &result); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoad_Map(b *testing.B) {
+	for _, n := range []int{100, 500, 2000} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			_, docs := makeBenchStruct(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var result map[string]interface{}
+				if _, err := Load(toml.Codec, benchReaders(docs), nil, nil, nil, &result); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchReaders(docs []string) []io.Reader {
+	readers := make([]io.Reader, len(docs))
+	for i := range docs {
+		readers[i] = strings.NewReader(docs[i])
+	}
+	return readers
+}
@@ -0,0 +1,156 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// LoadOption configures LoadInto.
+type LoadOption func(*loadOptions)
+
+// loadOptions gathers the arguments to Load() supplied via LoadOptions.
+type loadOptions struct {
+	codec         Codec
+	readerSources []readerSource
+	defaults      []Default
+	envOverrides  []EnvOverride
+	logger        *slog.Logger
+}
+
+// readerSource is either a set of readers (from WithReaders) or a set of file locations
+// to be found (from WithFiles).
+type readerSource struct {
+	readers       []io.Reader
+	readerNames   []string
+	fileLocations []FileLocation
+}
+
+// WithCodec sets the codec, as with the codec argument to Load(). Required.
+func WithCodec(codec Codec) LoadOption {
+	return func(opts *loadOptions) {
+		opts.codec = codec
+	}
+}
+
+// WithReaders adds config readers, as with the readers and readerNames arguments to
+// Load(). readerNames may be nil. Can be used more than once, and together with WithFiles;
+// the readers are used in the order the options are given.
+func WithReaders(readers []io.Reader, readerNames []string) LoadOption {
+	return func(opts *loadOptions) {
+		opts.readerSources = append(opts.readerSources, readerSource{readers: readers, readerNames: readerNames})
+	}
+}
+
+// WithFiles finds config files with FindFiles() and uses them as readers. The files are
+// closed before LoadInto returns.
+func WithFiles(fileLocations ...FileLocation) LoadOption {
+	return func(opts *loadOptions) {
+		// Finding the files is deferred until LoadInto is called, so that errors can be returned
+		opts.readerSources = append(opts.readerSources, readerSource{fileLocations: fileLocations})
+	}
+}
+
+// WithDefaults adds defaults, as with the defaults argument to Load().
+func WithDefaults(defaults ...Default) LoadOption {
+	return func(opts *loadOptions) {
+		opts.defaults = append(opts.defaults, defaults...)
+	}
+}
+
+// WithEnvOverrides adds environment variable overrides, as with the envOverrides argument
+// to Load().
+func WithEnvOverrides(envOverrides ...EnvOverride) LoadOption {
+	return func(opts *loadOptions) {
+		opts.envOverrides = append(opts.envOverrides, envOverrides...)
+	}
+}
+
+// WithLogger sets the logger for warnings from this load (including from finding files
+// for WithFiles), instead of the Logger variable. Unlike setting Logger, this doesn't
+// affect other loads.
+func WithLogger(logger *slog.Logger) LoadOption {
+	return func(opts *loadOptions) {
+		opts.logger = logger
+	}
+}
+
+// LoadInto is like Load(), but returns the populated config rather than taking a pointer
+// to it. T must be a struct type or map[string]interface{}. On error, the zero value of T
+// is returned.
+// For example:
+//  config, md, err := configloader.LoadInto[Config](
+//    configloader.WithCodec(toml.Codec),
+//    configloader.WithFiles(configloader.FileLocation{Filename: "config.toml", SearchPaths: []string{"."}}),
+//    configloader.WithEnvOverrides(envOverrides...))
+func LoadInto[T any](opts ...LoadOption) (result T, md Metadata, err error) {
+	var zero T
+
+	// Go's type constraints can't express "any struct type", so this check happens at runtime
+	resultType := reflect.TypeOf(&result).Elem()
+	_, isMap := interface{}(&result).(*map[string]interface{})
+	if resultType.Kind() != reflect.Struct && !isMap {
+		return zero, md, errors.Errorf("result type must be a struct or map[string]interface{}; got %s", resultType)
+	}
+
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.codec == nil {
+		return zero, md, errors.New("codec must be provided with WithCodec")
+	}
+
+	var readers []io.Reader
+	var readerNames []string
+	for _, rs := range o.readerSources {
+		if rs.readerNames != nil && len(rs.readerNames) != len(rs.readers) {
+			return zero, md, errors.New("readerNames must be nil or the same length as readers")
+		}
+
+		if rs.fileLocations != nil {
+			finder := diskFileFinder()
+			finder.logger = o.logger
+			fileReaders, closers, fileNames, err := finder.findFiles(rs.fileLocations)
+			if err != nil {
+				// Wrapped with %w (which pkg/errors doesn't support), so that callers can
+				// use errors.As to get the *FilesNotFoundError
+				return zero, md, fmt.Errorf("FindFiles failed: %w", err)
+			}
+			defer func() {
+				for _, c := range closers {
+					c.Close()
+				}
+			}()
+
+			rs.readers, rs.readerNames = fileReaders, fileNames
+		}
+
+		for i, r := range rs.readers {
+			// An empty name results in a provenance like "[0]", as when readerNames is nil
+			name := ""
+			if rs.readerNames != nil {
+				name = rs.readerNames[i]
+			}
+			readers = append(readers, r)
+			readerNames = append(readerNames, name)
+		}
+	}
+
+	md, err = load(decoder{codec: o.codec, logger: o.logger}, readers, readerNames, o.defaults, o.envOverrides, &result)
+	if err != nil {
+		return zero, md, err
+	}
+
+	return result, md, nil
+}
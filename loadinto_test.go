@@ -0,0 +1,161 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+type loadIntoConfig struct {
+	Name string `toml:"name"`
+	Port int    `toml:"port"`
+	Mode string `toml:"mode" conf:"optional"`
+}
+
+func TestLoadInto(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.toml"), []byte("name = \"from file\"\nport = 1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Clearenv()
+	os.Setenv("MODE", "env")
+
+	config, md, err := LoadInto[loadIntoConfig](
+		WithCodec(toml.Codec),
+		WithFiles(FileLocation{Filename: "config.toml", SearchPaths: []string{dir}}),
+		WithReaders(makeStringReaders([]string{"port = 2"}), []string{"override.toml"}),
+		WithReaders(makeStringReaders([]string{"port = 3"}), nil),
+		WithDefaults(Default{Key: Key{"Mode"}, Val: "default"}),
+		WithEnvOverrides(EnvOverride{EnvVar: "MODE", Key: Key{"Mode"}}))
+	if err != nil {
+		t.Fatalf("LoadInto failed: %v", err)
+	}
+
+	want := loadIntoConfig{Name: "from file", Port: 3, Mode: "env"}
+	if config != want {
+		t.Fatalf("config mismatch; got %+v, want %+v", config, want)
+	}
+
+	compareProvenances(t, md.Provenances, map[string]string{
+		"name": filepath.Join(dir, "config.toml"),
+		"port": "[2]",
+		"mode": "$MODE",
+	})
+	var portSrcs []string
+	for _, prov := range md.Provenances {
+		if prov.Key.String() == "port" {
+			for _, layer := range prov.History {
+				portSrcs = append(portSrcs, layer.Src)
+			}
+		}
+	}
+	if want := []string{filepath.Join(dir, "config.toml"), "override.toml", "[2]"}; !reflect.DeepEqual(portSrcs, want) {
+		t.Fatalf("port history mismatch; got %v, want %v", portSrcs, want)
+	}
+
+	m, _, err := LoadInto[map[string]interface{}](
+		WithCodec(toml.Codec),
+		WithReaders(makeStringReaders([]string{"a = 1"}), nil))
+	if err != nil {
+		t.Fatalf("LoadInto map failed: %v", err)
+	}
+	if !reflect.DeepEqual(m, map[string]interface{}{"a": int64(1)}) {
+		t.Fatalf("map mismatch; got %#v", m)
+	}
+}
+
+func TestLoadInto_Errors(t *testing.T) {
+	os.Clearenv()
+
+	tests := []struct {
+		name    string
+		load    func() (interface{}, error)
+		wantErr string
+	}{
+		{
+			name: "no codec",
+			load: func() (interface{}, error) {
+				c, _, err := LoadInto[loadIntoConfig](WithReaders(makeStringReaders([]string{"name = \"a\"\nport = 1"}), nil))
+				return c, err
+			},
+			wantErr: "codec must be provided",
+		},
+		{
+			name: "not a struct or map",
+			load: func() (interface{}, error) {
+				c, _, err := LoadInto[[]string](WithCodec(toml.Codec))
+				return c, err
+			},
+			wantErr: "result type must be a struct",
+		},
+		{
+			name: "pointer type",
+			load: func() (interface{}, error) {
+				c, _, err := LoadInto[*loadIntoConfig](WithCodec(toml.Codec))
+				return c, err
+			},
+			wantErr: "result type must be a struct",
+		},
+		{
+			name: "readerNames length mismatch",
+			load: func() (interface{}, error) {
+				c, _, err := LoadInto[loadIntoConfig](WithCodec(toml.Codec), WithReaders(makeStringReaders([]string{""}), []string{"a", "b"}))
+				return c, err
+			},
+			wantErr: "readerNames must be nil or the same length",
+		},
+		{
+			name: "missing file",
+			load: func() (interface{}, error) {
+				c, _, err := LoadInto[loadIntoConfig](WithCodec(toml.Codec), WithFiles(FileLocation{Filename: "nonexistent", SearchPaths: []string{"testdata"}}))
+				return c, err
+			},
+			wantErr: "FindFiles failed",
+		},
+		{
+			name: "zero value on Load error",
+			load: func() (interface{}, error) {
+				// port is missing, so Load fails after name has been set
+				c, _, err := LoadInto[loadIntoConfig](WithCodec(toml.Codec), WithReaders(makeStringReaders([]string{"name = \"a\""}), nil))
+				return c, err
+			},
+			wantErr: "missing required fields",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.load()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q; got %v", tt.wantErr, err)
+			}
+			if !reflect.ValueOf(got).IsZero() {
+				t.Fatalf("expected zero value; got %#v", got)
+			}
+		})
+	}
+}
+
+func TestLoadInto_FilesNotFound(t *testing.T) {
+	_, _, err := LoadInto[loadIntoConfig](WithCodec(toml.Codec),
+		WithFiles(FileLocation{Filename: "nonexistent", SearchPaths: []string{"testdata"}}))
+
+	var notFound *FilesNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected *FilesNotFoundError; got %T: %v", err, err)
+	}
+	if len(notFound.Missing) != 1 || notFound.Missing[0].Filename != "nonexistent" {
+		t.Fatalf("missing files mismatch: %+v", notFound.Missing)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+)
+
+// Logger, if set, receives warnings from Load() about config that was accepted but
+// probably doesn't do what was intended (for example, an empty map in a config file,
+// which does not clear the entries supplied by earlier files). Nil by default, which
+// disables logging. Can be modified if the caller desires.
+//
+// Logger is shared by every Load() in the process, and is read without synchronization,
+// so it must be set before any Load() (or FindFiles()) calls that may run concurrently,
+// and not changed afterwards. To use a logger for a single load, use WithLogger with
+// LoadInto() instead.
+var Logger *slog.Logger
+
+// logWarn logs a warning to logger, or to Logger if logger is nil. Nothing is logged if
+// both are nil.
+func logWarn(logger *slog.Logger, msg string, args ...interface{}) {
+	if logger == nil {
+		logger = Logger
+	}
+	if logger == nil {
+		return
+	}
+	logger.Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+// LogValue implements slog.LogValuer, so that keys are logged like "server.port".
+func (k Key) LogValue() slog.Value {
+	return slog.StringValue(k.String())
+}
+
+// LogValue implements slog.LogValuer. The provenance is logged as a group with its source
+// and final value (redacted if the field is secret). Note that this differs from
+// encoding/json, which leaves out the values (see ProvenanceLayer.Val). For example, with
+// a JSON handler:
+//   {"src":"config.toml","kind":"file","line":3,"column":1,"value":"debug"}
+func (prov Provenance) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("src", prov.Src),
+		slog.String("kind", prov.Kind.String()),
+	}
+	if prov.Line > 0 {
+		attrs = append(attrs, slog.Int("line", prov.Line), slog.Int("column", prov.Column))
+	}
+	if len(prov.History) > 0 {
+		attrs = append(attrs, slog.Any("value", prov.History[len(prov.History)-1].Val))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue implements slog.LogValuer. The provenances are logged as a group keyed by the
+// field key, sorted by key.
+func (provs Provenances) LogValue() slog.Value {
+	// Sort a copy, so that logging doesn't modify provs
+	sorted := make(Provenances, len(provs))
+	copy(sorted, provs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key.String() < sorted[j].Key.String() })
+
+	attrs := make([]slog.Attr, len(sorted))
+	for i, prov := range sorted {
+		attrs[i] = slog.Any(prov.Key.String(), prov.LogValue())
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// LogValue implements slog.LogValuer. The metadata is logged as a group containing the
+// config (ConfigMap, with the values of secret fields redacted) and the provenances.
+// This makes it safe to log, unlike ConfigMap itself.
+func (md Metadata) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("config", md.configLogValue(md.ConfigMap, nil)),
+		slog.Any("provenances", md.Provenances.LogValue()),
+	)
+}
+
+// configLogValue converts the config map m, found at key prefix, into a group, with the
+// values of secret fields redacted.
+func (md Metadata) configLogValue(m map[string]interface{}, prefix reflection.AliasedKey) slog.Value {
+	mapKeys := make([]string, 0, len(m))
+	for mk := range m {
+		mapKeys = append(mapKeys, mk)
+	}
+	sort.Strings(mapKeys)
+
+	attrs := make([]slog.Attr, len(mapKeys))
+	for i, mk := range mapKeys {
+		ak := append(append(reflection.AliasedKey{}, prefix...), reflection.AliasedKeyElem{mk})
+
+		var val slog.Value
+		if md.isSecret(ak) {
+			val = slog.StringValue(RedactedValue)
+		} else if subMap, ok := m[mk].(map[string]interface{}); ok {
+			val = md.configLogValue(subMap, ak)
+		} else {
+			val = slog.AnyValue(m[mk])
+		}
+		attrs[i] = slog.Attr{Key: mk, Value: val}
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,181 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func TestMetadata_LogValue(t *testing.T) {
+	type config struct {
+		Log struct {
+			Level string `toml:"level"`
+		} `toml:"log"`
+		Password string            `toml:"password" conf:"secret"`
+		Creds    map[string]string `toml:"creds" conf:"optional,secret"`
+		Absent   string            `conf:"optional"`
+	}
+
+	readers := makeStringReaders([]string{
+		"password = \"hunter2\"\n[log]\nlevel = \"debug\"\n[creds]\nuser = \"pw\"",
+	})
+
+	os.Clearenv()
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"config.toml"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger.Info("loaded", "md", md, "key", Key{"log", "level"})
+
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), `"pw"`) {
+		t.Fatalf("secret leaked into log: %s", buf.String())
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("bad log output: %v; %s", err, buf.String())
+	}
+	want := map[string]interface{}{
+		"msg": "loaded",
+		"md": map[string]interface{}{
+			"config": map[string]interface{}{
+				"Absent":   "",
+				"creds":    RedactedValue,
+				"log":      map[string]interface{}{"level": "debug"},
+				"password": RedactedValue,
+			},
+			"provenances": map[string]interface{}{
+				"Absent":     map[string]interface{}{"src": "[absent]", "kind": "absent"},
+				"creds.user": map[string]interface{}{"src": "config.toml", "kind": "file", "line": float64(5), "column": float64(1), "value": RedactedValue},
+				"log.level":  map[string]interface{}{"src": "config.toml", "kind": "file", "line": float64(3), "column": float64(1), "value": "debug"},
+				"password":   map[string]interface{}{"src": "config.toml", "kind": "file", "line": float64(1), "column": float64(1), "value": RedactedValue},
+			},
+		},
+		"key": "log.level",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("log output mismatch;\ngot  %#v\nwant %#v", got, want)
+	}
+}
+
+func TestProvenances_JSON(t *testing.T) {
+	type config struct {
+		Level    string `toml:"level"`
+		Password string `toml:"password" conf:"secret"`
+	}
+
+	readers := makeStringReaders([]string{"password = \"hunter2\"\nlevel = \"debug\""})
+	defaults := []Default{{Key: Key{"Level"}, Val: "info"}}
+
+	os.Clearenv()
+	var result config
+	md, err := Load(toml.Codec, readers, []string{"config.toml"}, defaults, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	b, err := json.Marshal(md.Provenances)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+
+	// Values are left out, whether or not they're secret
+	for _, val := range []string{"hunter2", "debug", "info", RedactedValue} {
+		if strings.Contains(string(b), val) {
+			t.Fatalf("value %q in serialized provenances: %s", val, b)
+		}
+	}
+
+	// But the rest of the history is there
+	var got []struct {
+		Src     string
+		History []map[string]interface{}
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if len(got) != 2 || len(got[0].History)+len(got[1].History) != 3 {
+		t.Fatalf("serialized provenances mismatch: %s", b)
+	}
+
+	// The values are still available
+	if got := md.Explain("level"); !strings.Contains(got, `"debug" (final)`) {
+		t.Fatalf("Explain mismatch: %s", got)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	defer func() { Logger = nil }()
+
+	var buf bytes.Buffer
+	Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	os.Clearenv()
+	var result map[string]interface{}
+	_, err := Load(toml.Codec, makeStringReaders([]string{"[m]\na = 1", "[m]"}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), "key=m") {
+		t.Fatalf("expected warning about empty map; got %q", buf.String())
+	}
+
+	// No warnings without an empty map
+	buf.Reset()
+	_, err = Load(toml.Codec, makeStringReaders([]string{"[m]\na = 1", "[m]\nb = 2"}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("unexpected log output: %q", buf.String())
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	defer func() { Logger = nil }()
+
+	var globalBuf, optBuf bytes.Buffer
+	Logger = slog.New(slog.NewTextHandler(&globalBuf, nil))
+
+	os.Clearenv()
+	_, _, err := LoadInto[map[string]interface{}](
+		WithCodec(toml.Codec),
+		WithFiles(FileLocation{Filename: "nonexistent", SearchPaths: []string{"$CONFIGLOADER_UNSET"}, Optional: true}),
+		WithReaders(makeStringReaders([]string{"[m]\na = 1", "[m]"}), nil),
+		WithLogger(slog.New(slog.NewTextHandler(&optBuf, nil))))
+	if err != nil {
+		t.Fatalf("LoadInto failed: %v", err)
+	}
+
+	// Both the FindFiles and Load warnings go to the option's logger
+	if !strings.Contains(optBuf.String(), "envVar=CONFIGLOADER_UNSET") || !strings.Contains(optBuf.String(), "key=m") {
+		t.Fatalf("expected warnings in WithLogger logger; got %q", optBuf.String())
+	}
+	if globalBuf.Len() != 0 {
+		t.Fatalf("unexpected output to Logger: %q", globalBuf.String())
+	}
+}
@@ -0,0 +1,333 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package properties provides Java-style .properties Codec methods for use with
+// configloader.
+//
+// The supported syntax is:
+//  # comment
+//  ! comment
+//  server.listen_port=8080
+//  server.hostname: example.com
+//  server.timeout 5s
+//  long.value = first part, \
+//               second part
+//  escaped\ key\=name = value with \t escapes and é
+// Dots in keys create nested maps, so the above results in a "server" map containing
+// "listen_port", "hostname" and "timeout". Later values for the same key replace earlier
+// ones. As in Java, whitespace after the separator is skipped, but trailing whitespace is
+// kept.
+//
+// Property values are untyped strings. They are converted to the types of the
+// corresponding result struct fields (see configloader.UntypedCodec and the untyped
+// package). Slice values are comma-separated.
+package properties
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/Psiphon-Inc/configloader-go/untyped"
+	"github.com/pkg/errors"
+)
+
+// KeySeparator separates the elements of nested keys.
+const KeySeparator = "."
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation. It also implements
+// configloader.UntypedCodec and configloader.PositionCodec.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	m, err := untyped.ToMap(v, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	flat := untyped.FlattenMap(m, KeySeparator)
+
+	var buf bytes.Buffer
+	for _, k := range untyped.SortedKeys(flat) {
+		s, ok := flat[k].(string)
+		if !ok {
+			// An empty map; there's nothing to write
+			continue
+		}
+		fmt.Fprintf(&buf, "%s=%s\n", escape(k, true), escape(s, false))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// escape escapes s so that it will survive a round trip as a key or value.
+func escape(s string, isKey bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r == ' ' && (isKey || i == 0):
+			// Leading whitespace in values would otherwise be skipped
+			sb.WriteString(`\ `)
+		case (r == '#' || r == '!') && i == 0:
+			// Would otherwise look like a comment
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case isKey && (r == '=' || r == ':'):
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	entries, err := parse(data)
+	if err != nil {
+		return err
+	}
+
+	nested, err := nest(entries)
+	if err != nil {
+		return err
+	}
+
+	if m, ok := v.(*map[string]interface{}); ok {
+		if *m == nil {
+			*m = make(map[string]interface{})
+		}
+		for k, val := range nested {
+			(*m)[k] = val
+		}
+		return nil
+	}
+
+	return untyped.Decode(nested, v, codec)
+}
+
+// entry is a single key and value from a properties file.
+type entry struct {
+	key          string
+	val          string
+	line, column int
+}
+
+// nest expands the dotted keys of entries into nested maps of strings.
+func nest(entries []entry) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for _, e := range entries {
+		keyElems := strings.Split(e.key, KeySeparator)
+
+		currMap := result
+		for i, keyElem := range keyElems {
+			if keyElem == "" {
+				return nil, errors.Errorf("line %d: empty element in key %q", e.line, e.key)
+			}
+
+			if i == len(keyElems)-1 {
+				if _, isMap := currMap[keyElem].(map[string]interface{}); isMap {
+					return nil, errors.Errorf("line %d: key %s conflicts with another key", e.line, e.key)
+				}
+				currMap[keyElem] = e.val
+				break
+			}
+
+			if currMap[keyElem] == nil {
+				currMap[keyElem] = make(map[string]interface{})
+			}
+			subMap, ok := currMap[keyElem].(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("line %d: key %s conflicts with another key", e.line, e.key)
+			}
+			currMap = subMap
+		}
+	}
+
+	return result, nil
+}
+
+// parse parses properties data into its entries, in order.
+func parse(data []byte) ([]entry, error) {
+	var entries []entry
+
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		column := len(lines[i]) - len(line) + 1
+
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		// A line ending with an unescaped backslash continues onto the next
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+
+		// The key ends at the first unescaped separator or whitespace
+		keyEnd := len(line)
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.IndexByte("=: \t\f", line[j]) >= 0 {
+				keyEnd = j
+				break
+			}
+		}
+
+		key, err := unescape(line[:keyEnd])
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: bad key", lineNum)
+		}
+		if key == "" {
+			return nil, errors.Errorf("line %d: empty key", lineNum)
+		}
+
+		// Skip whitespace, at most one '=' or ':', and more whitespace
+		rest := strings.TrimLeft(line[keyEnd:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+
+		val, err := unescape(rest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d: bad value for %s", lineNum, key)
+		}
+
+		entries = append(entries, entry{key: key, val: val, line: lineNum, column: column})
+	}
+
+	return entries, nil
+}
+
+// continues returns true if line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescape processes the backslash escapes in a key or value.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			// A trailing backslash at the end of the file; drop it
+			break
+		}
+
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", errors.Errorf("malformed \\u escape in %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", errors.Wrapf(err, "malformed \\u escape in %q", s)
+			}
+			var buf [utf8.UTFMax]byte
+			sb.Write(buf[:utf8.EncodeRune(buf[:], rune(r))])
+			i += 4
+		default:
+			// Any other escaped character is itself
+			sb.WriteByte(s[i])
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("properties") == "-"
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	if codec.IsStructFieldIgnored(st) {
+		return ""
+	}
+
+	if typeTag := st.Get("properties"); typeTag != "" {
+		return strings.Split(typeTag, ",")[0]
+	}
+
+	return ""
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	return untyped.FieldTypesConsistent(check, gold)
+}
+
+// ConvertString implements configloader.UntypedCodec.
+func (codec codecImplmentation) ConvertString(s string, field *reflection.StructField) (interface{}, error) {
+	return untyped.ConvertString(s, field)
+}
+
+// KeyPositions implements configloader.PositionCodec. A key that is set more than once
+// has the position of its last occurrence.
+func (codec codecImplmentation) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	entries, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var positions []reflection.KeyPosition
+	indexes := make(map[string]int)
+	for _, e := range entries {
+		pos := reflection.KeyPosition{Key: strings.Split(e.key, KeySeparator), Line: e.line, Column: e.column}
+		if i, ok := indexes[e.key]; ok {
+			positions[i] = pos
+			continue
+		}
+		indexes[e.key] = len(positions)
+		positions = append(positions, pos)
+	}
+
+	return positions, nil
+}
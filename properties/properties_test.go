@@ -0,0 +1,190 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package properties_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/properties"
+)
+
+func TestLoad(t *testing.T) {
+	type config struct {
+		Name   string `properties:"name"`
+		Server struct {
+			ListenPort uint16 `properties:"listen_port"`
+			Hostname   string
+			Timeout    time.Duration `properties:"timeout"`
+			Debug      bool          `properties:"debug" conf:"optional"`
+		} `properties:"server"`
+		Limits struct {
+			Ratio   float32 `properties:"ratio"`
+			Offsets []int   `properties:"offsets"`
+		} `properties:"limits"`
+		Labels  map[string]int `properties:"labels" conf:"optional"`
+		Ignored string         `properties:"-"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+# Java-style comment
+! another comment
+name = \  spaced
+server.listen_port=80
+server.HostName: example.com
+server.timeout 5s
+limits.ratio=0.5
+limits.offsets=1, \
+               -2, 3
+labels.a=1
+`),
+		strings.NewReader(`server.listen_port=8080
+  server.debug=true
+labels.b=2
+`),
+	}
+
+	var result config
+	md, err := configloader.Load(properties.Codec, readers, []string{"app.properties", "override.properties"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Name = "  spaced"
+	want.Server.ListenPort = 8080
+	want.Server.Hostname = "example.com"
+	want.Server.Timeout = 5 * time.Second
+	want.Server.Debug = true
+	want.Limits.Ratio = 0.5
+	want.Limits.Offsets = []int{1, -2, 3}
+	want.Labels = map[string]int{"a": 1, "b": 2}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	type position struct {
+		src          string
+		line, column int
+	}
+	wantProvs := map[string]position{
+		"name":               {"app.properties", 4, 1},
+		"server.listen_port": {"override.properties", 1, 1},
+		"server.Hostname":    {"app.properties", 6, 1},
+		"server.timeout":     {"app.properties", 7, 1},
+		"server.debug":       {"override.properties", 2, 3},
+		"limits.ratio":       {"app.properties", 8, 1},
+		"limits.offsets":     {"app.properties", 9, 1},
+		"labels.a":           {"app.properties", 11, 1},
+		"labels.b":           {"override.properties", 3, 1},
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		got := position{prov.Src, prov.Line, prov.Column}
+		if wantProvs[prov.Key.String()] != got {
+			t.Fatalf("provenance mismatch for %v; got %+v, want %+v", prov.Key, got, wantProvs[prov.Key.String()])
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	type config struct {
+		A int    `properties:"a"`
+		B string `properties:"-" conf:"optional"`
+		C struct {
+			D uint8 `properties:"d"`
+		} `properties:"c" conf:"optional"`
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"vestigial field", "a=1\nz=2\n"},
+		{"vestigial branch", "a=1\nz.y=1\n"},
+		{"ignored field", "a=1\nb=x\n"},
+		{"type mismatch", "a=abc\n"},
+		{"out of range", "a=1\nc.d=300\n"},
+		{"missing required", "\n"},
+		{"leaf and branch", "a=1\nc=1\nc.d=1\n"},
+		{"empty key element", "a=1\nc..d=1\n"},
+		{"bad unicode escape", "a=\\u12\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := configloader.Load(properties.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, nil, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	m := map[string]interface{}{
+		"b": "bee",
+		"a": int64(1),
+		"s": map[string]interface{}{
+			"x":     []interface{}{int64(1), int64(2)},
+			"sub":   map[string]interface{}{"y": " padded\tvalue "},
+			"k=e y": "#not a comment",
+		},
+	}
+
+	got, err := properties.Codec.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := "a=1\nb=bee\ns.k\\=e\\ y=\\#not a comment\ns.sub.y=\\ padded\\tvalue \ns.x=1, 2\n"
+	if string(got) != want {
+		t.Fatalf("Marshal mismatch;\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	var roundTrip map[string]interface{}
+	if err := properties.Codec.Unmarshal(got, &roundTrip); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	wantRoundTrip := map[string]interface{}{
+		"a": "1",
+		"b": "bee",
+		"s": map[string]interface{}{
+			"x":     "1, 2",
+			"sub":   map[string]interface{}{"y": " padded\tvalue "},
+			"k=e y": "#not a comment",
+		},
+	}
+	if !reflect.DeepEqual(roundTrip, wantRoundTrip) {
+		t.Fatalf("round trip mismatch;\ngot  %#v\nwant %#v", roundTrip, wantRoundTrip)
+	}
+}
+
+func TestUnmarshal_Escapes(t *testing.T) {
+	doc := "greeting = caf\\u00e9\\n\nempty\ncontinued = a\\\n  b\\\\\n"
+
+	var got map[string]interface{}
+	if err := properties.Codec.Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"greeting":  "café\n",
+		"empty":     "",
+		"continued": `ab\`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unmarshal mismatch;\ngot  %#v\nwant %#v", got, want)
+	}
+}
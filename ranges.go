@@ -0,0 +1,183 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+)
+
+// checkValueRanges checks that the numeric values in m (which was decoded by codec) fit
+// in the numeric types of the corresponding fields in structFields. For example, 300
+// doesn't fit in a uint8, -1 doesn't fit in a uint, 1e40 doesn't fit in a float32, and
+// 1.5 doesn't fit in an int. fieldTypesConsistent treats all numeric sizes as
+// equivalent, so this catches what it doesn't (and what codecs might otherwise silently
+// wrap or truncate).
+func checkValueRanges(m map[string]interface{}, codec Codec, structFields []*reflection.StructField) error {
+	fields := newFieldIndex(structFields)
+	mapFields := reflection.GetStructFields(m, TagName, codec)
+	for _, mapField := range mapFields {
+		if len(mapField.Children) > 0 {
+			// We only check leaves
+			continue
+		}
+
+		sf, exact := fields.find(mapField.AliasedKey)
+		if sf == nil || sf.ExpectedType != "" {
+			// Vestigial (which is checked elsewhere) or explicitly typed
+			continue
+		}
+
+		kind := numericKind(sf)
+		if !exact {
+			// The value might be inside a map within the struct, like map[string]uint8
+			kind = ""
+			if sf.Kind == "map" {
+				kind = mapValueType(sf.Type, len(mapField.AliasedKey)-len(sf.AliasedKey))
+			}
+		}
+		if kind == "" {
+			continue
+		}
+
+		val := valueAtKey(m, mapField.AliasedKey)
+		if err := checkNumberRange(val, kind); err != nil {
+			return &FieldError{Key: keyFromAliasedKey(mapField.AliasedKey), Err: err}
+		}
+	}
+
+	return nil
+}
+
+// numericKind returns the type name that should be used to check the range of values
+// for sf, like "uint8" or "[]float32", or "" if it can't be determined.
+func numericKind(sf *reflection.StructField) string {
+	typeName := strings.TrimLeft(sf.Type, "*")
+	if strings.HasPrefix(typeName, "[]") {
+		return "[]" + strings.TrimLeft(typeName[len("[]"):], "*")
+	}
+
+	if _, ok := numericRanges[typeName]; ok {
+		return typeName
+	}
+
+	// Might be a named type, like `type port uint16`
+	if _, ok := numericRanges[sf.Kind]; ok {
+		return sf.Kind
+	}
+
+	return ""
+}
+
+// mapValueType returns the type of the values depth levels deep in the map type mapType.
+// For example, mapValueType("map[string]uint8", 1) returns "uint8".
+func mapValueType(mapType string, depth int) string {
+	typeName := strings.TrimLeft(mapType, "*")
+	for i := 0; i < depth; i++ {
+		if !strings.HasPrefix(typeName, "map[") {
+			return ""
+		}
+		typeName = strings.TrimLeft(typeName[strings.Index(typeName, "]")+1:], "*")
+	}
+	return typeName
+}
+
+// valueAtKey returns the value in m at ak, which must exist. m is a plain map, so the
+// key elements don't have multiple aliases.
+func valueAtKey(m map[string]interface{}, ak reflection.AliasedKey) interface{} {
+	currMap := m
+	for _, keyElem := range ak[:len(ak)-1] {
+		currMap = currMap[keyElem[0]].(map[string]interface{})
+	}
+	return currMap[ak[len(ak)-1][0]]
+}
+
+// The limits of the numeric types, used by checkNumberRange. For floats, only max is used.
+var numericRanges = map[string]struct {
+	min int64
+	max uint64
+}{
+	"int":     {math.MinInt64 >> (64 - strconv.IntSize), math.MaxInt64 >> (64 - strconv.IntSize)},
+	"int8":    {math.MinInt8, math.MaxInt8},
+	"int16":   {math.MinInt16, math.MaxInt16},
+	"int32":   {math.MinInt32, math.MaxInt32},
+	"int64":   {math.MinInt64, math.MaxInt64},
+	"uint":    {0, math.MaxUint64 >> (64 - strconv.IntSize)},
+	"uint8":   {0, math.MaxUint8},
+	"uint16":  {0, math.MaxUint16},
+	"uint32":  {0, math.MaxUint32},
+	"uint64":  {0, math.MaxUint64},
+	"float32": {},
+	"float64": {},
+}
+
+// checkNumberRange returns an error if val is a number that doesn't fit in the numeric
+// type named by kind (like "uint8"). If kind is a slice type (like "[]uint8"), each
+// element of val is checked. If val or kind isn't numeric, nil is returned.
+func checkNumberRange(val interface{}, kind string) error {
+	if strings.HasPrefix(kind, "[]") {
+		rv := reflect.ValueOf(val)
+		if rv.Kind() != reflect.Slice {
+			return nil
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := checkNumberRange(rv.Index(i).Interface(), kind[len("[]"):]); err != nil {
+				return errors.Wrapf(err, "bad slice element %d", i)
+			}
+		}
+		return nil
+	}
+
+	limits, ok := numericRanges[kind]
+	if !ok {
+		return nil
+	}
+
+	rv := reflect.ValueOf(val)
+	isFloatKind := strings.HasPrefix(kind, "float")
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		if !isFloatKind && (i < limits.min || (i > 0 && uint64(i) > limits.max)) {
+			return errors.Errorf("value %d out of range for %s", i, kind)
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if !isFloatKind && u > limits.max {
+			return errors.Errorf("value %d out of range for %s", u, kind)
+		}
+
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if kind == "float32" {
+			if !math.IsInf(f, 0) && math.Abs(f) > math.MaxFloat32 {
+				return errors.Errorf("value %g out of range for %s", f, kind)
+			}
+			return nil
+		}
+		if isFloatKind {
+			return nil
+		}
+
+		// Integer kind
+		if f != math.Trunc(f) {
+			return errors.Errorf("value %g is not an integer, so can't be used for %s", f, kind)
+		}
+		if f < float64(limits.min) || (f > 0 && f >= math.Ldexp(float64(limits.max/2+1), 1)) {
+			return errors.Errorf("value %g out of range for %s", f, kind)
+		}
+	}
+
+	return nil
+}
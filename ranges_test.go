@@ -0,0 +1,119 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/json"
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func Test_checkNumberRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     interface{}
+		kind    string
+		wantErr bool
+	}{
+		{"int8 fits", int64(127), "int8", false},
+		{"int8 too big", int64(128), "int8", true},
+		{"int8 too small", int64(-129), "int8", true},
+		{"uint8 fits", int64(255), "uint8", false},
+		{"uint8 too big", int64(300), "uint8", true},
+		{"uint negative", int64(-1), "uint", true},
+		{"uint64 max", uint64(math.MaxUint64), "uint64", false},
+		{"int64 from big uint64", uint64(math.MaxUint64), "int64", true},
+		{"int from int", 5, "int", false},
+		{"float32 fits", 1e38, "float32", false},
+		{"float32 too big", 1e40, "float32", true},
+		{"float32 too small", -1e40, "float32", true},
+		{"float64 huge", 1e300, "float64", false},
+		{"int into float", int64(math.MaxInt64), "float32", false},
+		{"integral float into int", 3.0, "int16", false},
+		{"non-integral float into int", 1.5, "int", true},
+		{"float too big for int64", 9.3e18, "int64", true},
+		{"float max for uint8", 255.0, "uint8", false},
+		{"float too big for uint8", 256.0, "uint8", true},
+		{"negative float into uint", -1.0, "uint32", true},
+		{"slice fits", []interface{}{int64(1), int64(2)}, "[]uint8", false},
+		{"slice element too big", []interface{}{int64(1), int64(256)}, "[]uint8", true},
+		{"not a number", "300", "uint8", false},
+		{"not a numeric kind", int64(300), "string", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNumberRange(tt.val, tt.kind)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkNumberRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_RangeErrors(t *testing.T) {
+	type port uint16
+	type config struct {
+		Small   int8
+		Port    port              `toml:"port"`
+		Count   uint              `conf:"optional"`
+		Ratio   float32           `conf:"optional"`
+		Limits  map[string]uint8  `conf:"optional"`
+		Offsets []int8            `conf:"optional"`
+		Ptr     *uint8            `conf:"optional"`
+		Nested  map[string][]int8 `conf:"optional"`
+	}
+
+	tests := []struct {
+		name     string
+		codec    Codec
+		doc      string
+		defaults []Default
+		wantKey  string
+	}{
+		{"named type", toml.Codec, "Small = 1\nport = 70000", nil, "port"},
+		{"int8", json.Codec, `{"Small": 128, "port": 1}`, nil, "Small"},
+		{"negative uint", toml.Codec, "Small = 1\nport = 1\nCount = -1", nil, "Count"},
+		{"float32", toml.Codec, "Small = 1\nport = 1\nRatio = 1e40", nil, "Ratio"},
+		{"map value", toml.Codec, "Small = 1\nport = 1\n[Limits]\na = 300", nil, "Limits.a"},
+		{"slice element", json.Codec, `{"Small": 1, "port": 1, "Offsets": [1, -200]}`, nil, "Offsets"},
+		{"pointer", toml.Codec, "Small = 1\nport = 1\nPtr = 256", nil, "Ptr"},
+		{"default", toml.Codec, "Small = 1\nport = 1", []Default{{Key: Key{"Count"}, Val: -5}}, "Count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := Load(tt.codec, makeStringReaders([]string{tt.doc}), []string{"the_reader"}, tt.defaults, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+
+			msg := err.Error()
+			if !strings.Contains(msg, "out of range") {
+				t.Fatalf("error should be a range error: %v", err)
+			}
+			if !strings.Contains(msg, tt.wantKey) {
+				t.Fatalf("error should contain key %s: %v", tt.wantKey, err)
+			}
+			if tt.defaults == nil && !strings.Contains(msg, "the_reader") {
+				t.Fatalf("error should contain the reader name: %v", err)
+			}
+		})
+	}
+
+	// Values that fit are fine, including integers into unsigned fields
+	var result config
+	_, err := Load(toml.Codec, makeStringReaders([]string{"Small = -128\nport = 65535\nCount = 1\nRatio = 1.5\n[Limits]\na = 255\n[Nested]\nx = [1, 2]"}), nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if result.Port != 65535 || result.Small != -128 || result.Limits["a"] != 255 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package reflection
+
+import (
+	"reflect"
+	"sync"
+)
+
+// The key of structFieldsCache. codec must be comparable.
+type structFieldsCacheKey struct {
+	typ     reflect.Type
+	tagName string
+	codec   Codec
+}
+
+// structFieldsCacheKey -> []*StructField
+var structFieldsCache sync.Map
+
+/*
+GetStructFieldsCached is like GetStructFields, but the result for a struct type is
+computed once and then shared by all callers (with the same tagName and codec). It is safe
+for concurrent use.
+
+The returned fields are shared, so they MUST NOT be modified.
+
+GetStructFields walks values, not just types: the contents of non-nil pointers and
+interfaces and the entries of maps become fields too. So the cache is only used when obj
+is a struct (or a pointer to one) in which all of those are nil or empty, as they are in a
+newly declared config struct. Otherwise, or if codec isn't comparable (so can't be part of
+the cache key), this is the same as GetStructFields.
+*/
+func GetStructFieldsCached(obj interface{}, tagName string, codec Codec) []*StructField {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct || codec == nil || !reflect.TypeOf(codec).Comparable() ||
+		!hasTypeOnlyFields(v, codec) {
+		return GetStructFields(obj, tagName, codec)
+	}
+
+	key := structFieldsCacheKey{v.Type(), tagName, codec}
+	if fields, ok := structFieldsCache.Load(key); ok {
+		return fields.([]*StructField)
+	}
+
+	// Use a zero value of the type, so that nothing about obj's values gets into the cache
+	fields := GetStructFields(reflect.New(v.Type()).Interface(), tagName, codec)
+
+	// Limit the capacity so that appending to the result can't write into the shared array
+	fields = fields[:len(fields):len(fields)]
+
+	// If another goroutine got here first, use its result, so all callers get the same one
+	actual, _ := structFieldsCache.LoadOrStore(key, fields)
+	return actual.([]*StructField)
+}
+
+// hasTypeOnlyFields returns true if GetStructFields would give the same result for the
+// struct v as for a zero value of its type. That is, if the pointers, interfaces, and maps
+// within v (that GetStructFields would walk) are nil or empty.
+func hasTypeOnlyFields(v reflect.Value, codec Codec) bool {
+	for i := 0; i < v.NumField(); i++ {
+		fieldType := v.Type().Field(i)
+		if fieldType.PkgPath != "" || codec.IsStructFieldIgnored(fieldType.Tag) {
+			// Not walked by GetStructFields
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if !field.IsNil() {
+				return false
+			}
+		case reflect.Map:
+			if field.Len() > 0 {
+				return false
+			}
+		case reflect.Struct:
+			if !hasTypeOnlyFields(field, codec) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package reflection
+
+import (
+	"sync"
+	"testing"
+)
+
+// Not comparable, so can't be used as a cache key
+type funcCodec struct {
+	codecImplmentation
+	f func()
+}
+
+func TestGetStructFieldsCached(t *testing.T) {
+	type inner struct {
+		M map[string]int
+	}
+	type cachedStruct struct {
+		A     int `testtype:"a" conf:"optional"`
+		Inner inner
+		P     *inner
+		I     interface{}
+		Skip  map[string]int `testtype:"-"`
+	}
+
+	compare := func(t *testing.T, got, want []*StructField) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("length mismatch; got %d, want %d", len(got), len(want))
+		}
+		for i := range got {
+			if !compareStructFields(*got[i], *want[i]) {
+				t.Fatalf("field %d mismatch; got %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	}
+
+	// Zero values are cached and shared
+	var s cachedStruct
+	first := GetStructFieldsCached(&s, confTag, codec)
+	compare(t, first, GetStructFields(&s, confTag, codec))
+	if second := GetStructFieldsCached(cachedStruct{Skip: map[string]int{"x": 1}}, confTag, codec); &second[0] != &first[0] {
+		t.Fatalf("expected cached result to be shared")
+	}
+	if cap(first) != len(first) {
+		t.Fatalf("expected capacity to be limited; got len %d, cap %d", len(first), cap(first))
+	}
+
+	// Values that affect the result are not cached
+	tests := []struct {
+		name string
+		obj  cachedStruct
+	}{
+		{"map entries", cachedStruct{Inner: inner{M: map[string]int{"x": 1}}}},
+		{"non-nil pointer", cachedStruct{P: &inner{}}},
+		{"non-nil interface", cachedStruct{I: map[string]interface{}{"x": 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetStructFieldsCached(&tt.obj, confTag, codec)
+			compare(t, got, GetStructFields(&tt.obj, confTag, codec))
+			if len(got) == len(first) {
+				t.Fatalf("expected value fields to be included")
+			}
+		})
+	}
+
+	// Different tag names and codecs get different results
+	tagged := GetStructFieldsCached(&s, "other", codec)
+	if tagged[0].Optional {
+		t.Fatalf("expected tag name to be part of the cache key")
+	}
+	fc := funcCodec{f: func() {}}
+	compare(t, GetStructFieldsCached(&s, confTag, fc), GetStructFields(&s, confTag, fc))
+
+	// Concurrent use (for the race detector)
+	type concurrentStruct struct{ A, B int }
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			GetStructFieldsCached(&concurrentStruct{}, confTag, codec)
+		}()
+	}
+	wg.Wait()
+}
@@ -93,6 +93,10 @@ type StructField struct {
 	// If the strut tag contains an explicit type, it will be provided here.
 	ExpectedType string
 
+	// true if the field has been flagged as secret in the struct tag (or is within a
+	// field that has been); false otherwise. Values of secret fields should not be logged.
+	Secret bool
+
 	// Pointer to the parent of the field (for non-roots)
 	Parent *StructField
 	// Pointers to the children of this field (for non-leafs)
@@ -112,7 +116,8 @@ GetStructFields returns information about the structure of obj. It can be passed
 a struct or a map. The non-exported fields of a struct are ignored.
 
 tagName is the struct tag name that will be used to flag whether a field is optional and
-if there is an explicit type that should be associated with it.
+if there is an explicit type that should be associated with it. It may also flag the
+field as secret, like `conf:"optional,secret"`.
 
 codec implements Codec and is used to determine if fields have an alias or should be
 ignored. (I.e., with the `json:` or `toml:` struct tags.)
@@ -249,12 +254,26 @@ func (d decoder) makeField(keyPrefix AliasedKey, name string, structTag *reflect
 		}
 
 		tagOpts := strings.Split(structTag.Get(d.tagName), ",")
-		sf.Optional = (tagOpts[0] == "optional")
+
+		// "secret" may appear in any position; the other options are positional
+		for i := 0; i < len(tagOpts); i++ {
+			if tagOpts[i] == "secret" {
+				sf.Secret = true
+				tagOpts = append(tagOpts[:i], tagOpts[i+1:]...)
+				i--
+			}
+		}
+
+		sf.Optional = (len(tagOpts) > 0 && tagOpts[0] == "optional")
 		if len(tagOpts) > 1 && tagOpts[1] != "" {
 			sf.ExpectedType = tagOpts[1]
 		}
 	}
 
+	if parent != nil && parent.Secret {
+		sf.Secret = true
+	}
+
 	// If the type of v implements encoding.TextUnmarshaler, then we expect a string
 	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
 		sf.ExpectedType = "string"
@@ -296,6 +315,11 @@ func (sf StructField) String() string {
 		sb.WriteString("\tExpectedType:\n")
 	}
 
+	if sf.Secret {
+		// Only included when set, to keep the common output uncluttered
+		sb.WriteString("\tSecret: true\n")
+	}
+
 	if sf.Parent != nil {
 		sb.WriteString(fmt.Sprintf("\tParent: %v\n", sf.Parent.AliasedKey))
 	} else {
@@ -316,3 +340,13 @@ func (sf StructField) String() string {
 
 	return sb.String()
 }
+
+// KeyPosition is the position of a key within config data. Codecs that can report
+// positions provide these (see configloader.PositionCodec).
+type KeyPosition struct {
+	// The key as it appears in the config data (i.e., using aliases, if used).
+	Key []string
+
+	// The 1-based line and column of the key. Zero if unknown.
+	Line, Column int
+}
@@ -180,6 +180,58 @@ func Test_getStructFields(t *testing.T) {
 					ExpectedType: "",
 				}},
 		},
+		{
+			name: "secret fields",
+			obj: struct {
+				A string `conf:"secret"`
+				B string `conf:"optional,secret"`
+				C int    `conf:"secret,,int64"`
+				D struct {
+					D1 string
+				} `conf:"optional,secret"`
+			}{},
+			want: []StructField{
+				{
+					AliasedKey: AliasedKey{{"A"}},
+					Type:       "string",
+					Kind:       "string",
+					Secret:     true,
+				},
+				{
+					AliasedKey: AliasedKey{{"B"}},
+					Type:       "string",
+					Kind:       "string",
+					Optional:   true,
+					Secret:     true,
+				},
+				{
+					AliasedKey:   AliasedKey{{"C"}},
+					Type:         "int",
+					Kind:         "int",
+					ExpectedType: "int64",
+					Secret:       true,
+				},
+				{
+					AliasedKey: AliasedKey{{"D"}},
+					Type:       "struct { D1 string }",
+					Kind:       "struct",
+					Optional:   true,
+					Secret:     true,
+					Children: []*StructField{
+						{
+							AliasedKey: AliasedKey{{"D"}, {"D1"}},
+						},
+					},
+				},
+				{
+					AliasedKey: AliasedKey{{"D"}, {"D1"}},
+					Type:       "string",
+					Kind:       "string",
+					Secret:     true,
+					Parent:     &StructField{AliasedKey: AliasedKey{{"D"}}},
+				},
+			},
+		},
 		{
 			name: "sub-structs",
 			obj: struct {
@@ -539,6 +591,10 @@ func compareStructFields(got, want StructField) bool {
 		return false
 	}
 
+	if got.Secret != want.Secret {
+		return false
+	}
+
 	if (got.Parent != nil) != (want.Parent != nil) {
 		return false
 	}
@@ -0,0 +1,204 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"reflect"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+)
+
+// DocTagName is used in struct tags like `doc:"The port to listen on"` to give fields
+// documentation text, which is included by Describe(). Can be modified if the caller
+// desires.
+var DocTagName = "doc"
+
+// Schema describes the fields of a config struct. See Describe().
+type Schema struct {
+	// The top-level fields of the struct, in struct order
+	Fields []*SchemaField
+}
+
+// SchemaField describes a field of a config struct.
+type SchemaField struct {
+	// The key of the field, using codec aliases where they exist (as with Provenance.Key)
+	Key Key
+
+	// The names that match the field in config: the Go field name and then the codec
+	// alias, if there is one (as with reflection.AliasedKeyElem)
+	Aliases []string
+
+	// The index of the field within its parent struct, for reflect.Value.Field
+	Index int
+
+	// The Go type of the field
+	Type reflect.Type
+
+	// The field's complete struct tag
+	Tag reflect.StructTag
+
+	// If the config value must be of a particular type, this is it. It is either given in
+	// the struct tag (like `conf:",string"`) or is "string" for fields that implement
+	// encoding.TextUnmarshaler.
+	ExpectedType string
+
+	// true if the field is tagged optional or has a default (or is a struct whose fields
+	// are all optional and one of which has a default). Note that the fields within an
+	// optional struct are only required if the struct is present.
+	Optional bool
+
+	// The default value of the field, if HasDefault is true (from WithDefaults)
+	Default    interface{}
+	HasDefault bool
+
+	// The environment variable that overrides the field (from WithEnvOverrides), or "".
+	// If more than one does, this is the last, which takes precedence in Load().
+	EnvVar string
+
+	// true if the field is tagged secret (or is within a field that is)
+	Secret bool
+
+	// The field's documentation text, from the DocTagName struct tag
+	Doc string
+
+	// The fields of a struct field, in struct order. Maps have no children, as their keys
+	// aren't known until config is loaded.
+	Children []*SchemaField
+}
+
+// Describe returns the schema of the config struct result, which may be a struct or a
+// pointer to one. The options are the same as for LoadInto(): WithCodec determines the
+// aliases of the fields, and WithDefaults and WithEnvOverrides add their information to
+// the matching fields. Other options are ignored. If WithCodec isn't given, fields have no
+// aliases.
+//
+// Describe is based on the type of result, not its values, so (as with Load) pointers
+// and interfaces are not descended into. If result isn't a struct, the Schema is empty.
+//
+// The Schema is intended for building tools like documentation generators and validators.
+// See JSONSchema() for an example.
+func Describe(result interface{}, opts ...LoadOption) Schema {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var codec reflection.Codec = noAliasCodec{}
+	if o.codec != nil {
+		codec = o.codec
+	}
+
+	resultType := reflect.TypeOf(result)
+	for resultType != nil && resultType.Kind() == reflect.Ptr {
+		resultType = resultType.Elem()
+	}
+	if resultType == nil || resultType.Kind() != reflect.Struct {
+		return Schema{}
+	}
+
+	// Use a zero value, so that the values in result don't affect the fields
+	structFields := reflection.GetStructFieldsCached(reflect.New(resultType).Interface(), TagName, codec)
+	fields := newFieldIndex(structFields)
+
+	var topLevel []*reflection.StructField
+	for _, sf := range structFields {
+		if sf.Parent == nil {
+			topLevel = append(topLevel, sf)
+		}
+	}
+
+	bySF := make(map[*reflection.StructField]*SchemaField, len(structFields))
+	schemaFields := describeStruct(resultType, topLevel, codec, bySF)
+
+	// Attach the defaults and env overrides to the fields they match exactly
+	for _, dflt := range o.defaults {
+		if sf, exact := fields.find(aliasedKeyFromKey(dflt.Key)); exact {
+			bySF[sf].Default, bySF[sf].HasDefault = dflt.Val, true
+
+			bySF[sf].Optional = true
+
+			// The default makes its ancestor structs present too, so config only needs to
+			// supply them if they have fields that are still required
+			for parent := sf.Parent; parent != nil && allOptional(bySF[parent].Children); parent = parent.Parent {
+				bySF[parent].Optional = true
+			}
+		}
+	}
+	for _, eo := range o.envOverrides {
+		if sf, exact := fields.find(aliasedKeyFromKey(eo.Key)); exact {
+			bySF[sf].EnvVar = eo.EnvVar
+		}
+	}
+
+	return Schema{Fields: schemaFields}
+}
+
+// allOptional returns true if all of fields are optional.
+func allOptional(fields []*SchemaField) bool {
+	for _, f := range fields {
+		if !f.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// describeStruct creates the SchemaFields for the struct type t, whose fields (as from
+// GetStructFields) are structFields. Each SchemaField created is added to bySF, keyed by
+// its StructField.
+func describeStruct(t reflect.Type, structFields []*reflection.StructField, codec reflection.Codec,
+	bySF map[*reflection.StructField]*SchemaField) []*SchemaField {
+	var schemaFields []*SchemaField
+
+	// structFields has the fields of t in order, skipping the same fields that we skip
+	next := 0
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || codec.IsStructFieldIgnored(field.Tag) {
+			continue
+		}
+		if next >= len(structFields) {
+			break
+		}
+		sf := structFields[next]
+		next++
+
+		// sf is shared (see GetStructFieldsCached), so slices from it must be copied
+		schemaField := &SchemaField{
+			Key:          keyFromAliasedKey(sf.AliasedKey),
+			Aliases:      append([]string(nil), sf.AliasedKey[len(sf.AliasedKey)-1]...),
+			Index:        i,
+			Type:         field.Type,
+			Tag:          field.Tag,
+			ExpectedType: sf.ExpectedType,
+			Optional:     sf.Optional,
+			Secret:       sf.Secret,
+			Doc:          field.Tag.Get(DocTagName),
+		}
+
+		if len(sf.Children) > 0 && field.Type.Kind() == reflect.Struct {
+			schemaField.Children = describeStruct(field.Type, sf.Children, codec, bySF)
+		}
+
+		bySF[sf] = schemaField
+		schemaFields = append(schemaFields, schemaField)
+	}
+
+	return schemaFields
+}
+
+// noAliasCodec is used by Describe if no codec is given. Fields have no aliases and none
+// are ignored.
+type noAliasCodec struct{}
+
+func (noAliasCodec) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return false
+}
+
+func (noAliasCodec) GetStructFieldAlias(st reflect.StructTag) string {
+	return ""
+}
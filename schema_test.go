@@ -0,0 +1,106 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+type describeConfig struct {
+	Server struct {
+		Host string `toml:"host" doc:"The host to listen on"`
+		Port int    `toml:"port"`
+	} `toml:"server"`
+	Password string            `toml:"password" conf:",secret"`
+	Timeout  time.Duration     `toml:"timeout" conf:"optional,string"`
+	Start    time.Time         `toml:"start" conf:"optional"`
+	Labels   map[string]string `toml:"labels" conf:"optional"`
+	Ignored  string            `toml:"-"`
+	internal string
+}
+
+func TestDescribe(t *testing.T) {
+	var config describeConfig
+	schema := Describe(&config,
+		WithCodec(toml.Codec),
+		WithDefaults(Default{Key: Key{"Server", "Port"}, Val: 8080}),
+		WithEnvOverrides(
+			EnvOverride{EnvVar: "PASSWORD", Key: Key{"password"}},
+			EnvOverride{EnvVar: "APP_PASSWORD", Key: Key{"Password"}}))
+
+	type summary struct {
+		key          string
+		aliases      []string
+		index        int
+		typ          string
+		expectedType string
+		optional     bool
+		dflt         interface{}
+		envVar       string
+		secret       bool
+		doc          string
+		children     int
+	}
+	var got []summary
+	var walk func([]*SchemaField)
+	walk = func(fields []*SchemaField) {
+		for _, f := range fields {
+			if !f.HasDefault && f.Default != nil {
+				t.Fatalf("Default set without HasDefault for %s", f.Key)
+			}
+			got = append(got, summary{f.Key.String(), f.Aliases, f.Index, f.Type.String(), f.ExpectedType,
+				f.Optional, f.Default, f.EnvVar, f.Secret, f.Doc, len(f.Children)})
+			walk(f.Children)
+		}
+	}
+	walk(schema.Fields)
+
+	want := []summary{
+		{key: "server", aliases: []string{"Server", "server"}, index: 0, typ: "struct { Host string \"toml:\\\"host\\\" doc:\\\"The host to listen on\\\"\"; Port int \"toml:\\\"port\\\"\" }", children: 2},
+		{key: "server.host", aliases: []string{"Host", "host"}, index: 0, typ: "string", doc: "The host to listen on"},
+		{key: "server.port", aliases: []string{"Port", "port"}, index: 1, typ: "int", optional: true, dflt: 8080},
+		{key: "password", aliases: []string{"Password", "password"}, index: 1, typ: "string", envVar: "APP_PASSWORD", secret: true},
+		{key: "timeout", aliases: []string{"Timeout", "timeout"}, index: 2, typ: "time.Duration", expectedType: "string", optional: true},
+		{key: "start", aliases: []string{"Start", "start"}, index: 3, typ: "time.Time", expectedType: "string", optional: true},
+		{key: "labels", aliases: []string{"Labels", "labels"}, index: 4, typ: "map[string]string", optional: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("schema mismatch;\ngot  %+v\nwant %+v", got, want)
+	}
+
+	if tag := schema.Fields[1].Tag.Get("conf"); tag != ",secret" {
+		t.Fatalf("tag mismatch; got %q", tag)
+	}
+
+	// The schema depends only on the type, and modifying it doesn't affect later calls
+	config.Labels = map[string]string{"a": "b"}
+	schema.Fields[0].Aliases[0] = "modified"
+	again := Describe(config, WithCodec(toml.Codec))
+	if len(again.Fields) != len(schema.Fields) || len(again.Fields[4].Children) != 0 {
+		t.Fatalf("expected the same fields; got %+v", again.Fields)
+	}
+	if again.Fields[0].Aliases[0] != "Server" {
+		t.Fatalf("expected aliases to be unaffected; got %v", again.Fields[0].Aliases)
+	}
+
+	// Without a codec, there are no aliases
+	noCodec := Describe(config)
+	if got := noCodec.Fields[0].Aliases; !reflect.DeepEqual(got, []string{"Server"}) {
+		t.Fatalf("expected no aliases without codec; got %v", got)
+	}
+
+	// Non-structs have no fields
+	for _, v := range []interface{}{nil, map[string]interface{}{}, 1} {
+		if s := Describe(v, WithCodec(toml.Codec)); len(s.Fields) != 0 {
+			t.Fatalf("expected empty schema for %T; got %+v", v, s)
+		}
+	}
+}
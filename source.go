@@ -0,0 +1,92 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// SourceKind is the kind of source that a field value came from.
+type SourceKind int
+
+const (
+	// SourceAbsent indicates that the field was not set at all.
+	SourceAbsent SourceKind = iota
+	// SourceDefault indicates that the value came from the defaults passed to Load().
+	SourceDefault
+	// SourceFile indicates that the value came from one of the readers passed to Load()
+	// (typically a file).
+	SourceFile
+	// SourceEnv indicates that the value came from an environment variable override.
+	SourceEnv
+	// SourceFlag indicates that the value came from a command-line flag. Load() doesn't
+	// produce this itself; it is provided for callers that layer flags on top of config.
+	SourceFlag
+)
+
+var sourceKindNames = []string{"absent", "default", "file", "env", "flag"}
+
+// String returns the name of the kind, like "file".
+func (sk SourceKind) String() string {
+	if sk < 0 || int(sk) >= len(sourceKindNames) {
+		return fmt.Sprintf("SourceKind(%d)", int(sk))
+	}
+	return sourceKindNames[sk]
+}
+
+// MarshalText implements encoding.TextMarshaler, so that kinds are logged and encoded
+// (in JSON, for example) by name.
+func (sk SourceKind) MarshalText() (text []byte, err error) {
+	return []byte(sk.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (sk *SourceKind) UnmarshalText(text []byte) error {
+	for i, name := range sourceKindNames {
+		if name == string(text) {
+			*sk = SourceKind(i)
+			return nil
+		}
+	}
+	return errors.Errorf("unknown SourceKind: %q", text)
+}
+
+// source describes where a field value came from. It is stored in the Kind, Name, and
+// Index fields of Provenance and ProvenanceLayer.
+type source struct {
+	kind  SourceKind
+	name  string
+	index int
+}
+
+// String returns the source in the form used by Provenance.Src:
+//   "path/to/file.toml": SourceFile, if readerNames was provided to Load()
+//   "[0]": SourceFile, if readerNames was not provided to Load()
+//   "[default]": SourceDefault
+//   "[absent]": SourceAbsent
+//   "$ENV_VAR_NAME": SourceEnv
+//   "-flag-name": SourceFlag
+func (src source) String() string {
+	switch src.kind {
+	case SourceFile:
+		if src.name != "" {
+			return src.name
+		}
+		return fmt.Sprintf("[%d]", src.index)
+	case SourceDefault:
+		return "[default]"
+	case SourceEnv:
+		return "$" + src.name
+	case SourceFlag:
+		return "-" + src.name
+	case SourceAbsent:
+		return "[absent]"
+	}
+	return fmt.Sprintf("[%s]", src.kind)
+}
@@ -0,0 +1,119 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package configloader
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/Psiphon-Inc/configloader-go/toml"
+)
+
+func TestSourceKind_Text(t *testing.T) {
+	for i, name := range []string{"absent", "default", "file", "env", "flag"} {
+		sk := SourceKind(i)
+		if sk.String() != name {
+			t.Fatalf("String mismatch; got %q, want %q", sk.String(), name)
+		}
+
+		text, err := sk.MarshalText()
+		if err != nil || string(text) != name {
+			t.Fatalf("MarshalText mismatch; got %q (%v), want %q", text, err, name)
+		}
+
+		var got SourceKind
+		if err := got.UnmarshalText(text); err != nil || got != sk {
+			t.Fatalf("UnmarshalText mismatch; got %v (%v), want %v", got, err, sk)
+		}
+	}
+
+	var sk SourceKind
+	if err := sk.UnmarshalText([]byte("nope")); err == nil {
+		t.Fatalf("UnmarshalText should fail for unknown kind")
+	}
+
+	b, err := json.Marshal(struct{ Kind SourceKind }{SourceEnv})
+	if err != nil || string(b) != `{"Kind":"env"}` {
+		t.Fatalf("json.Marshal mismatch; got %s (%v)", b, err)
+	}
+}
+
+func TestLoad_SourceKinds(t *testing.T) {
+	type config struct {
+		A string
+		B string
+		C string
+		D string `conf:"optional"`
+		E string
+	}
+
+	defaults := []Default{{Key: Key{"C"}, Val: "c"}}
+
+	os.Clearenv()
+	os.Setenv("E_VAR", "e")
+	envOverrides := []EnvOverride{{EnvVar: "E_VAR", Key: Key{"E"}}}
+
+	type source struct {
+		src   string
+		kind  SourceKind
+		name  string
+		index int
+	}
+	tests := []struct {
+		name        string
+		readerNames []string
+		want        map[string]source
+	}{
+		{
+			name:        "with reader names",
+			readerNames: []string{"first.toml", "second.toml"},
+			want: map[string]source{
+				"A": {"first.toml", SourceFile, "first.toml", 0},
+				"B": {"second.toml", SourceFile, "second.toml", 1},
+				"C": {"[default]", SourceDefault, "", 0},
+				"D": {"[absent]", SourceAbsent, "", 0},
+				"E": {"$E_VAR", SourceEnv, "E_VAR", 0},
+			},
+		},
+		{
+			name:        "without reader names",
+			readerNames: nil,
+			want: map[string]source{
+				"A": {"[0]", SourceFile, "", 0},
+				"B": {"[1]", SourceFile, "", 1},
+				"C": {"[default]", SourceDefault, "", 0},
+				"D": {"[absent]", SourceAbsent, "", 0},
+				"E": {"$E_VAR", SourceEnv, "E_VAR", 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			md, err := Load(toml.Codec, makeStringReaders([]string{`A = "a"`, `B = "b"`}), tt.readerNames, defaults, envOverrides, &result)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+
+			if len(md.Provenances) != len(tt.want) {
+				t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, tt.want)
+			}
+			for _, prov := range md.Provenances {
+				got := source{prov.Src, prov.Kind, prov.Name, prov.Index}
+				if got != tt.want[prov.Key.String()] {
+					t.Fatalf("provenance mismatch for %v; got %+v, want %+v", prov.Key, got, tt.want[prov.Key.String()])
+				}
+				for _, layer := range prov.History {
+					if layer.Src != prov.Src || layer.Kind != prov.Kind || layer.Name != prov.Name || layer.Index != prov.Index {
+						t.Fatalf("history mismatch for %v; got %+v", prov.Key, layer)
+					}
+				}
+			}
+		})
+	}
+}
@@ -9,7 +9,9 @@ package toml
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -18,7 +20,8 @@ import (
 
 type codecImplmentation struct{}
 
-// Codec is the configloader.Codec implementation.
+// Codec is the configloader.Codec implementation. It also implements
+// configloader.PositionCodec.
 var Codec = codecImplmentation{}
 
 func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
@@ -56,3 +59,183 @@ func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string
 func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
 	return false, errors.New("toml has no special FieldTypesConsistent checks")
 }
+
+// KeyPositions implements configloader.PositionCodec.
+//
+// The version of BurntSushi/toml that we use doesn't report key positions in its
+// MetaData, so we find them with a simple scan of the document. Keys within inline
+// tables and arrays of tables are not reported. data should already be known to be valid
+// TOML (i.e., Unmarshal should be called first).
+func (codec codecImplmentation) KeyPositions(data []byte) ([]reflection.KeyPosition, error) {
+	var positions []reflection.KeyPosition
+
+	// The key of the current [table]; nil within an [[array.of.tables]]
+	table := []string{}
+	// State that carries over from one line to the next
+	var sc valueScanner
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		lineNum := i + 1
+
+		if sc.inValue() {
+			// Continuation of a multi-line string or array
+			sc.scan(line)
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		column := len(line) - len(trimmed) + 1
+		trimmed = strings.TrimRight(trimmed, " \t\r")
+
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[[") {
+			table = nil
+			continue
+		}
+
+		if trimmed[0] == '[' {
+			end := keyEnd(trimmed[1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: malformed table header", lineNum)
+			}
+			key, err := splitKey(trimmed[1 : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			table = key
+			positions = append(positions, reflection.KeyPosition{Key: key, Line: lineNum, Column: column})
+			continue
+		}
+
+		eq := keyEnd(trimmed, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+
+		sc.scan(trimmed[eq+1:])
+
+		if table == nil {
+			// We can't give meaningful keys within arrays of tables
+			continue
+		}
+
+		key, err := splitKey(trimmed[:eq])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		fullKey := append(append([]string{}, table...), key...)
+		positions = append(positions, reflection.KeyPosition{Key: fullKey, Line: lineNum, Column: column})
+	}
+
+	return positions, nil
+}
+
+// keyEnd returns the index of the first unquoted occurrence of delim in s, or -1.
+func keyEnd(s string, delim byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == delim:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitKey splits a (possibly dotted and quoted) TOML key into its elements.
+func splitKey(s string) ([]string, error) {
+	var key []string
+	for {
+		s = strings.TrimSpace(s)
+		end := keyEnd(s, '.')
+		if end < 0 {
+			end = len(s)
+		}
+
+		elem := strings.TrimSpace(s[:end])
+		switch {
+		case strings.HasPrefix(elem, `"`):
+			unquoted, err := strconv.Unquote(elem)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted key %s", elem)
+			}
+			elem = unquoted
+		case strings.HasPrefix(elem, "'"):
+			elem = strings.Trim(elem, "'")
+		case elem == "":
+			return nil, errors.New("empty key")
+		}
+		key = append(key, elem)
+
+		if end == len(s) {
+			return key, nil
+		}
+		s = s[end+1:]
+	}
+}
+
+// valueScanner tracks whether a TOML value continues onto following lines, because it
+// is a multi-line string or an array (or inline table) that hasn't been closed.
+type valueScanner struct {
+	// The delimiter of the multi-line string we're in, if any
+	multiline string
+	// How deeply nested in arrays and inline tables we are
+	depth int
+}
+
+func (sc *valueScanner) inValue() bool {
+	return sc.multiline != "" || sc.depth > 0
+}
+
+// scan processes (the value portion of) a line.
+func (sc *valueScanner) scan(s string) {
+	for i := 0; i < len(s); i++ {
+		if sc.multiline != "" {
+			if sc.multiline == `"""` && s[i] == '\\' {
+				i++
+				continue
+			}
+			if strings.HasPrefix(s[i:], sc.multiline) {
+				i += len(sc.multiline) - 1
+				sc.multiline = ""
+			}
+			continue
+		}
+
+		switch s[i] {
+		case '#':
+			// The rest of the line is a comment
+			return
+		case '"', '\'':
+			delim := s[i : i+1]
+			if strings.HasPrefix(s[i:], delim+delim+delim) {
+				sc.multiline = delim + delim + delim
+				i += 2
+				continue
+			}
+			// A single-line string; skip to its end
+			for i++; i < len(s) && s[i] != delim[0]; i++ {
+				if delim == `"` && s[i] == '\\' {
+					i++
+				}
+			}
+		case '[', '{':
+			sc.depth++
+		case ']', '}':
+			sc.depth--
+		}
+	}
+}
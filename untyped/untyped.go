@@ -0,0 +1,644 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package untyped provides helpers for implementing Codecs for config languages whose
+// values are untyped strings (like INI or .properties files). Such codecs rely on the
+// result struct's field types to convert the strings into typed values.
+package untyped
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+)
+
+// Derive these once for type checks below.
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// SliceSeparator separates the elements of slice values, like "a, b, c".
+// Whitespace around elements is trimmed.
+const SliceSeparator = ","
+
+// The types we know how to convert to by their name (as found in StructField.Type).
+var basicTypes = map[string]reflect.Type{
+	"string":        reflect.TypeOf(""),
+	"bool":          reflect.TypeOf(false),
+	"int":           reflect.TypeOf(int(0)),
+	"int8":          reflect.TypeOf(int8(0)),
+	"int16":         reflect.TypeOf(int16(0)),
+	"int32":         reflect.TypeOf(int32(0)),
+	"int64":         reflect.TypeOf(int64(0)),
+	"uint":          reflect.TypeOf(uint(0)),
+	"uint8":         reflect.TypeOf(uint8(0)),
+	"uint16":        reflect.TypeOf(uint16(0)),
+	"uint32":        reflect.TypeOf(uint32(0)),
+	"uint64":        reflect.TypeOf(uint64(0)),
+	"float32":       reflect.TypeOf(float32(0)),
+	"float64":       reflect.TypeOf(float64(0)),
+	"time.Duration": durationType,
+}
+
+// ConvertString converts s into a value suitable for the struct field sf, for use in
+// configloader.UntypedCodec.ConvertString implementations. The result uses the types
+// that typed codecs produce: int64 for signed integers, uint64 for unsigned integers,
+// float64 for floats, and []interface{} for slices (whose elements are separated by
+// SliceSeparator). time.Duration fields accept strings like "5s".
+//
+// If the field expects a string (including because it implements
+// encoding.TextUnmarshaler), or its type can't be determined from sf (like a pointer to
+// a named type), s is returned unchanged.
+func ConvertString(s string, sf *reflection.StructField) (interface{}, error) {
+	if sf.ExpectedType == "string" {
+		return s, nil
+	}
+
+	t := typeForField(sf)
+	if t == nil {
+		return s, nil
+	}
+
+	if t.Kind() == reflect.Slice {
+		elemType := basicTypes[strings.TrimLeft(t.Elem().String(), "*")]
+		result := make([]interface{}, 0)
+		for _, elem := range splitSlice(s) {
+			if elemType == nil {
+				result = append(result, elem)
+				continue
+			}
+
+			v, err := ConvertToType(elem, elemType)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, normalize(v))
+		}
+		return result, nil
+	}
+
+	v, err := ConvertToType(s, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalize(v), nil
+}
+
+// typeForField determines the type to convert to for sf, or nil if it can't be determined.
+func typeForField(sf *reflection.StructField) reflect.Type {
+	typeName := sf.ExpectedType
+	if typeName == "" {
+		typeName = strings.TrimLeft(sf.Type, "*")
+	}
+
+	if t, ok := basicTypes[typeName]; ok {
+		return t
+	}
+
+	if strings.HasPrefix(typeName, "[]") {
+		if elemType, ok := basicTypes[strings.TrimLeft(typeName[len("[]"):], "*")]; ok {
+			return reflect.SliceOf(elemType)
+		}
+		// We can still split it up, even if we can't convert the elements
+		return reflect.TypeOf([]string{})
+	}
+
+	if strings.HasPrefix(typeName, "map[") {
+		// Value in a map-within-a-struct, like "map[string]int"
+		if i := strings.Index(typeName, "]"); i >= 0 {
+			if elemType, ok := basicTypes[strings.TrimLeft(typeName[i+1:], "*")]; ok {
+				return elemType
+			}
+		}
+		return nil
+	}
+
+	// Might be a named type, like `type stringAlias string`; use the kind
+	if t, ok := basicTypes[sf.Kind]; ok {
+		return t
+	}
+
+	return nil
+}
+
+// normalize converts v to the type that typed codecs would produce.
+func normalize(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	}
+	return v.Interface()
+}
+
+func splitSlice(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	parts := strings.Split(s, SliceSeparator)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// ConvertToType converts s into a value of type t. Types that implement
+// encoding.TextUnmarshaler use it, and time.Duration accepts strings like "5s".
+func ConvertToType(s string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		v := reflect.New(t)
+		if err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "UnmarshalText failed for %q", s)
+		}
+		return v.Elem(), nil
+	}
+
+	v := reflect.New(t).Elem()
+
+	if t == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			// Might just be a number of nanoseconds
+			i, intErr := strconv.ParseInt(s, 10, 64)
+			if intErr != nil {
+				return reflect.Value{}, errors.Wrapf(err, "bad duration value %q", s)
+			}
+			d = time.Duration(i)
+		}
+		v.SetInt(int64(d))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "bad bool value %q", s)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Always decimal: config files aren't Go source, so "0100" is 100, not octal
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "bad %s value %q", t, s)
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "bad %s value %q", t, s)
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return reflect.Value{}, errors.Wrapf(err, "bad %s value %q", t, s)
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		parts := splitSlice(s)
+		v = reflect.MakeSlice(t, len(parts), len(parts))
+		for i := range parts {
+			elem, err := ConvertToType(parts[i], t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(i).Set(elem)
+		}
+
+	case reflect.Ptr:
+		elem, err := ConvertToType(s, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v = reflect.New(t.Elem())
+		v.Elem().Set(elem)
+
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(s))
+
+	default:
+		return reflect.Value{}, errors.Errorf("cannot convert string %q to %s", s, t)
+	}
+
+	return v, nil
+}
+
+// FieldTypesConsistent provides the codec-specific type checks needed by untyped codecs,
+// for use in configloader.Codec.FieldTypesConsistent implementations.
+func FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	// ConvertString produces uint64 for all unsigned integer types
+	if strings.HasPrefix(check.Kind, "uint") && strings.HasPrefix(gold.Kind, "uint") {
+		return true, nil
+	}
+
+	// ConvertString leaves strings unconverted if it can't determine the type
+	if check.Kind == "string" && gold.Kind == "ptr" {
+		return true, nil
+	}
+
+	return false, errors.Errorf("field types inconsistent")
+}
+
+// Decode populates v (which must be a pointer to a struct or map) from m, whose leaves
+// are strings (or []string). Strings are converted to the types of the fields they're
+// assigned to. Keys are matched to struct fields case-insensitively, using the field
+// names and the aliases provided by codec. Keys that don't match a field are ignored
+// (configloader will have already rejected them).
+func Decode(m map[string]interface{}, v interface{}, codec reflection.Codec) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.Errorf("decode target must be a non-nil pointer; got %T", v)
+	}
+
+	return decodeValue(m, rv.Elem(), codec, nil)
+}
+
+// Recursion helper for Decode. path is used for error messages.
+func decodeValue(src interface{}, dst reflect.Value, codec reflection.Codec, path []string) error {
+	if src == nil {
+		return nil
+	}
+
+	if s, ok := src.(string); ok {
+		converted, err := ConvertToType(s, dst.Type())
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode '%s'", strings.Join(path, "."))
+		}
+		dst.Set(converted)
+		return nil
+	}
+
+	if dst.Kind() == reflect.Ptr {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decodeValue(src, dst.Elem(), codec, path)
+	}
+
+	switch srcVal := src.(type) {
+	case map[string]interface{}:
+		switch dst.Kind() {
+		case reflect.Struct:
+			return decodeStruct(srcVal, dst, codec, path)
+
+		case reflect.Map:
+			if dst.Type().Key().Kind() != reflect.String {
+				return errors.Errorf("cannot decode into map with non-string keys at '%s'", strings.Join(path, "."))
+			}
+			if dst.IsNil() {
+				dst.Set(reflect.MakeMap(dst.Type()))
+			}
+			for k, val := range srcVal {
+				elem := reflect.New(dst.Type().Elem()).Elem()
+				if err := decodeValue(val, elem, codec, append(path, k)); err != nil {
+					return err
+				}
+				dst.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+			}
+			return nil
+
+		case reflect.Interface:
+			dst.Set(reflect.ValueOf(srcVal))
+			return nil
+		}
+
+	case []string:
+		if dst.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(dst.Type(), len(srcVal), len(srcVal))
+			for i := range srcVal {
+				if err := decodeValue(srcVal[i], slice.Index(i), codec, append(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			dst.Set(slice)
+			return nil
+		}
+	}
+
+	srcValue := reflect.ValueOf(src)
+	if srcValue.Type().ConvertibleTo(dst.Type()) {
+		dst.Set(srcValue.Convert(dst.Type()))
+		return nil
+	}
+
+	return errors.Errorf("cannot decode %T into %s at '%s'", src, dst.Type(), strings.Join(path, "."))
+}
+
+func decodeStruct(src map[string]interface{}, dst reflect.Value, codec reflection.Codec, path []string) error {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if field.PkgPath != "" || codec.IsStructFieldIgnored(field.Tag) {
+			continue
+		}
+
+		names := []string{field.Name}
+		if alias := codec.GetStructFieldAlias(field.Tag); alias != "" {
+			names = append(names, alias)
+		}
+
+		for k, val := range src {
+			matched := false
+			for _, name := range names {
+				if strings.EqualFold(k, name) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			if err := decodeValue(val, dst.Field(i), codec, append(path, k)); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// ToMap converts v (a struct or map, or a pointer to one) into a map whose leaves are
+// strings, using the aliases provided by codec. This is intended for use in
+// Codec.Marshal implementations. Slices are joined with SliceSeparator. Values that
+// implement encoding.TextMarshaler are marshaled with it. Nil values are omitted.
+func ToMap(v interface{}, codec reflection.Codec) (map[string]interface{}, error) {
+	result, err := toMapValue(reflect.ValueOf(v), codec)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("value must be a struct or map; got %T", v)
+	}
+	return m, nil
+}
+
+// Recursion helper for ToMap. Returns nil for nil values.
+func toMapValue(v reflect.Value, codec reflection.Codec) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Type().Implements(textMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil, nil
+		}
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(text), nil
+	}
+
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return toMapValue(v.Elem(), codec)
+
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" || codec.IsStructFieldIgnored(field.Tag) {
+				continue
+			}
+
+			name := field.Name
+			if alias := codec.GetStructFieldAlias(field.Tag); alias != "" {
+				name = alias
+			}
+
+			fieldVal, err := toMapValue(v.Field(i), codec)
+			if err != nil {
+				return nil, err
+			}
+			if fieldVal != nil {
+				m[name] = fieldVal
+			}
+		}
+		return m, nil
+
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			elemVal, err := toMapValue(v.MapIndex(k), codec)
+			if err != nil {
+				return nil, err
+			}
+			if elemVal != nil {
+				m[fmt.Sprint(k.Interface())] = elemVal
+			}
+		}
+		return m, nil
+
+	case reflect.Slice, reflect.Array:
+		parts := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elemVal, err := toMapValue(v.Index(i), codec)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := elemVal.(string)
+			if !ok {
+				return nil, errors.Errorf("slice elements must be scalar; got %T", elemVal)
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, SliceSeparator+" "), nil
+	}
+
+	return fmt.Sprint(v.Interface()), nil
+}
+
+// SortedKeys returns the keys of m in sorted order. Useful for producing deterministic
+// Marshal output.
+func SortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SplitFlatKey splits a flat key (like "SERVER_LISTENPORT", from a .env file) into the
+// key path of the struct field it refers to (like ["Server", "ListenPort"]), for config
+// languages that don't have nested keys. v is (a pointer to) the result struct, and codec
+// provides the field aliases. Each element of the flat key must match the field name or
+// alias (case-insensitively), and the elements are joined with sep.
+// If the key path leads into a map field, the rest of the flat key is the map key. If the
+// map's values are structs (or maps), the rest is split into the map key and the key
+// within the value, like ["Backends", "eu", "URL"] for "BACKENDS_eu_URL".
+// Returns nil if the key doesn't match any field.
+func SplitFlatKey(flatKey, sep string, v interface{}, codec reflection.Codec) []string {
+	return splitFlatKeyType(flatKey, sep, reflect.TypeOf(v), codec)
+}
+
+// splitFlatKeyType splits flatKey against the type t, which is a struct or map type.
+func splitFlatKeyType(flatKey, sep string, t reflect.Type, codec reflection.Codec) []string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || flatKey == "" {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+			return nil
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" || codec.IsStructFieldIgnored(field.Tag) {
+				continue
+			}
+
+			names := []string{field.Name}
+			if alias := codec.GetStructFieldAlias(field.Tag); alias != "" {
+				names = append(names, alias)
+			}
+			for _, name := range names {
+				if strings.EqualFold(flatKey, name) {
+					return []string{name}
+				}
+
+				prefix := name + sep
+				if len(flatKey) <= len(prefix) || !strings.EqualFold(flatKey[:len(prefix)], prefix) {
+					continue
+				}
+				if sub := splitFlatKeyType(flatKey[len(prefix):], sep, field.Type, codec); sub != nil {
+					return append([]string{name}, sub...)
+				}
+			}
+		}
+
+	case reflect.Map:
+		elemType := t.Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if !isBranchType(elemType) {
+			// The whole of the rest of the key is a key within the map
+			return []string{flatKey}
+		}
+
+		// The map key is followed by a key within the value. The map key may itself
+		// contain sep, so try each position.
+		for i := strings.Index(flatKey, sep); i > 0; {
+			if sub := splitFlatKeyType(flatKey[i+len(sep):], sep, elemType, codec); sub != nil {
+				return append([]string{flatKey[:i]}, sub...)
+			}
+			next := strings.Index(flatKey[i+len(sep):], sep)
+			if next < 0 {
+				break
+			}
+			i += len(sep) + next
+		}
+	}
+
+	return nil
+}
+
+// isBranchType returns true if values of type t have keys within them (like structs and
+// maps), rather than being leaves.
+func isBranchType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map:
+		return true
+	case reflect.Struct:
+		return !reflect.PtrTo(t).Implements(textUnmarshalerType)
+	}
+	return false
+}
+
+// NestFlatKeys converts flat, which has flat keys (like "SERVER_LISTENPORT"), into a
+// nested map (like {"Server": {"ListenPort": ...}}), using SplitFlatKey with the given
+// separator, result struct v, and codec. Keys that don't match any field are left as-is
+// (and will be treated by configloader as vestigial).
+func NestFlatKeys(flat map[string]interface{}, sep string, v interface{}, codec reflection.Codec) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+
+	for _, flatKey := range SortedKeys(flat) {
+		key := SplitFlatKey(flatKey, sep, v, codec)
+		if key == nil {
+			key = []string{flatKey}
+		}
+
+		currMap := result
+		for i, keyElem := range key {
+			if i == len(key)-1 {
+				if _, exists := currMap[keyElem]; exists {
+					return nil, errors.Errorf("flat key %s conflicts with another key", flatKey)
+				}
+				currMap[keyElem] = flat[flatKey]
+				break
+			}
+
+			if currMap[keyElem] == nil {
+				currMap[keyElem] = make(map[string]interface{})
+			}
+			subMap, ok := currMap[keyElem].(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("flat key %s conflicts with another key", flatKey)
+			}
+			currMap = subMap
+		}
+	}
+
+	return result, nil
+}
+
+// FlattenMap is the reverse of NestFlatKeys: it converts the nested map m into a flat
+// map, joining key elements with sep.
+func FlattenMap(m map[string]interface{}, sep string) map[string]interface{} {
+	result := make(map[string]interface{})
+	flattenMapRecursive(m, sep, "", result)
+	return result
+}
+
+func flattenMapRecursive(m map[string]interface{}, sep, prefix string, result map[string]interface{}) {
+	for k, v := range m {
+		if subMap, ok := v.(map[string]interface{}); ok {
+			flattenMapRecursive(subMap, sep, prefix+k+sep, result)
+			continue
+		}
+		result[prefix+k] = v
+	}
+}
@@ -0,0 +1,261 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package untyped
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+)
+
+func TestConvertString(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		sf      reflection.StructField
+		want    interface{}
+		wantErr bool
+	}{
+		{"string", "abc", reflection.StructField{Type: "string", Kind: "string"}, "abc", false},
+		{"named string", "abc", reflection.StructField{Type: "main.alias", Kind: "string"}, "abc", false},
+		{"int", "-12", reflection.StructField{Type: "int", Kind: "int"}, int64(-12), false},
+		{"leading zero int", "0100", reflection.StructField{Type: "int", Kind: "int"}, int64(100), false},
+		{"leading zero not octal", "08", reflection.StructField{Type: "int", Kind: "int"}, int64(8), false},
+		{"leading zero uint", "0100", reflection.StructField{Type: "uint16", Kind: "uint16"}, uint64(100), false},
+		{"hex int not supported", "0x10", reflection.StructField{Type: "int", Kind: "int"}, nil, true},
+		{"int8 overflow", "128", reflection.StructField{Type: "int8", Kind: "int8"}, nil, true},
+		{"uint", "12", reflection.StructField{Type: "uint", Kind: "uint"}, uint64(12), false},
+		{"uint negative", "-1", reflection.StructField{Type: "uint", Kind: "uint"}, nil, true},
+		{"pointer", "12", reflection.StructField{Type: "*int", Kind: "ptr"}, int64(12), false},
+		{"float", "1.5", reflection.StructField{Type: "float32", Kind: "float32"}, float64(1.5), false},
+		{"bool", "true", reflection.StructField{Type: "bool", Kind: "bool"}, true, false},
+		{"bad bool", "yes please", reflection.StructField{Type: "bool", Kind: "bool"}, nil, true},
+		{"duration", "1m", reflection.StructField{Type: "time.Duration", Kind: "int64"}, int64(time.Minute), false},
+		{"duration nanoseconds", "1000", reflection.StructField{Type: "time.Duration", Kind: "int64"}, int64(1000), false},
+		{"text unmarshaler", "2019-01-01", reflection.StructField{Type: "time.Time", Kind: "struct", ExpectedType: "string"}, "2019-01-01", false},
+		{"explicit type", "1.5", reflection.StructField{Type: "float64", Kind: "float64", ExpectedType: "float32"}, float64(1.5), false},
+		{"slice", "1, 2 ,3", reflection.StructField{Type: "[]int", Kind: "slice"}, []interface{}{int64(1), int64(2), int64(3)}, false},
+		{"empty slice", "", reflection.StructField{Type: "[]int", Kind: "slice"}, []interface{}{}, false},
+		{"slice of unknown", "a,b", reflection.StructField{Type: "[]main.T", Kind: "slice"}, []interface{}{"a", "b"}, false},
+		{"bad slice elem", "1,x", reflection.StructField{Type: "[]int", Kind: "slice"}, nil, true},
+		{"map value", "7", reflection.StructField{Type: "map[string]int", Kind: "map"}, int64(7), false},
+		{"map of unknown", "7", reflection.StructField{Type: "map[string]interface {}", Kind: "map"}, "7", false},
+		{"unknown", "x", reflection.StructField{Type: "*main.T", Kind: "ptr"}, "x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ConvertString(tt.s, &tt.sf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConvertString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ConvertString() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+type testCodec struct{}
+
+func (testCodec) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("test") == "-"
+}
+
+func (testCodec) GetStructFieldAlias(st reflect.StructTag) string {
+	return strings.Split(st.Get("test"), ",")[0]
+}
+
+func TestDecodeAndToMap(t *testing.T) {
+	type inner struct {
+		N *int          `test:"n"`
+		D time.Duration `test:"d"`
+	}
+	type strct struct {
+		S       string `test:"ess"`
+		U       uint32
+		F       float64
+		B       bool
+		L       []string
+		T       time.Time
+		I       inner             `test:"inner"`
+		M       map[string]int    `test:"m"`
+		Any     interface{}       `test:"any"`
+		Ignored string            `test:"-"`
+		Empty   map[string]string `test:"empty"`
+	}
+
+	src := map[string]interface{}{
+		"ESS": "hello",
+		"u":   "42",
+		"F":   "2.5",
+		"b":   "true",
+		"l":   "x, y",
+		"t":   "2019-01-02T03:04:05Z",
+		"inner": map[string]interface{}{
+			"n": "7",
+			"d": "1h",
+		},
+		"m":       map[string]interface{}{"a": "1"},
+		"any":     "anything",
+		"ignored": "nope",
+		"unknown": "ignored too",
+	}
+
+	var got strct
+	if err := Decode(src, &got, testCodec{}); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	n := 7
+	want := strct{
+		S:   "hello",
+		U:   42,
+		F:   2.5,
+		B:   true,
+		L:   []string{"x", "y"},
+		T:   time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC),
+		I:   inner{N: &n, D: time.Hour},
+		M:   map[string]int{"a": 1},
+		Any: "anything",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Decode mismatch;\ngot  %#v\nwant %#v", got, want)
+	}
+
+	gotMap, err := ToMap(&got, testCodec{})
+	if err != nil {
+		t.Fatalf("ToMap failed: %v", err)
+	}
+	wantMap := map[string]interface{}{
+		"ess": "hello",
+		"U":   "42",
+		"F":   "2.5",
+		"B":   "true",
+		"L":   "x, y",
+		"T":   "2019-01-02T03:04:05Z",
+		"inner": map[string]interface{}{
+			"n": "7",
+			"d": "1h0m0s",
+		},
+		"m":     map[string]interface{}{"a": "1"},
+		"any":   "anything",
+		"empty": map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(gotMap, wantMap) {
+		t.Fatalf("ToMap mismatch;\ngot  %#v\nwant %#v", gotMap, wantMap)
+	}
+
+	// Round trip
+	var roundTrip strct
+	if err := Decode(gotMap, &roundTrip, testCodec{}); err != nil {
+		t.Fatalf("Decode of ToMap result failed: %v", err)
+	}
+	want.Empty = map[string]string{}
+	if !reflect.DeepEqual(roundTrip, want) {
+		t.Fatalf("round trip mismatch;\ngot  %#v\nwant %#v", roundTrip, want)
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	type strct struct {
+		I int
+		S struct {
+			A int
+		}
+	}
+
+	tests := []struct {
+		name string
+		src  map[string]interface{}
+		dst  interface{}
+	}{
+		{"bad int", map[string]interface{}{"i": "x"}, &strct{}},
+		{"map into int", map[string]interface{}{"i": map[string]interface{}{}}, &strct{}},
+		{"not a pointer", map[string]interface{}{}, strct{}},
+		{"nil pointer", map[string]interface{}{}, (*strct)(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Decode(tt.src, tt.dst, testCodec{}); err == nil {
+				t.Fatalf("Decode should have failed")
+			}
+		})
+	}
+}
+
+func TestNestFlatKeys(t *testing.T) {
+	type strct struct {
+		Server struct {
+			ListenPort int
+			Host       string `test:"host_name"`
+		}
+		Server_Extra string
+		Labels       map[string]string
+		Backends     map[string]*struct {
+			URL     string
+			Timeout time.Duration
+		}
+	}
+	v := &strct{}
+
+	flat := map[string]interface{}{
+		"SERVER_LISTENPORT":    "1",
+		"server_host_name":     "h",
+		"SERVER_EXTRA":         "x",
+		"LABELS_a_b":           "ab",
+		"BACKENDS_ap_east_URL": "z",
+		"BACKENDS_eu_TIMEOUT":  "1s",
+		"BACKENDS_eu_PORT":     "p",
+		"UNKNOWN_KEY":          "u",
+	}
+
+	got, err := NestFlatKeys(flat, "_", v, testCodec{})
+	if err != nil {
+		t.Fatalf("NestFlatKeys failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"Server": map[string]interface{}{
+			"ListenPort": "1",
+			"host_name":  "h",
+		},
+		"Server_Extra": "x",
+		"Labels":       map[string]interface{}{"a_b": "ab"},
+		"Backends": map[string]interface{}{
+			"ap_east": map[string]interface{}{"URL": "z"},
+			"eu":      map[string]interface{}{"Timeout": "1s"},
+		},
+		// No field of the map's values matches, so it isn't split
+		"BACKENDS_eu_PORT": "p",
+		"UNKNOWN_KEY":      "u",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("NestFlatKeys mismatch;\ngot  %#v\nwant %#v", got, want)
+	}
+
+	if !reflect.DeepEqual(FlattenMap(got, "_"), map[string]interface{}{
+		"Server_ListenPort":    "1",
+		"Server_host_name":     "h",
+		"Server_Extra":         "x",
+		"Labels_a_b":           "ab",
+		"Backends_ap_east_URL": "z",
+		"Backends_eu_Timeout":  "1s",
+		"BACKENDS_eu_PORT":     "p",
+		"UNKNOWN_KEY":          "u",
+	}) {
+		t.Fatalf("FlattenMap mismatch: %#v", FlattenMap(got, "_"))
+	}
+
+	// A key can't be both a leaf and a branch
+	_, err = NestFlatKeys(map[string]interface{}{"SERVER": "1", "SERVER_LISTENPORT": "2"}, "_", v, testCodec{})
+	if err == nil {
+		t.Fatalf("NestFlatKeys should have failed")
+	}
+}
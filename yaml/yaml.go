@@ -0,0 +1,215 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+// Package yaml provides YAML Codec methods for use with configloader.
+//
+// It is a separate package so that users who don't need YAML don't pick up the dependency.
+package yaml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Psiphon-Inc/configloader-go/reflection"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type codecImplmentation struct{}
+
+// Codec is the configloader.Codec implementation.
+var Codec = codecImplmentation{}
+
+func (codec codecImplmentation) Marshal(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+// Unmarshal decodes YAML data into v.
+//
+// yaml.v2 decodes nested maps as map[interface{}]interface{}, but configloader requires
+// map[string]interface{}, so nested maps are converted when v is a map.
+//
+// yaml.v2 also matches keys to struct fields case-sensitively, using the lowercased
+// field name when there's no alias. configloader (like encoding/json and BurntSushi/toml)
+// matches keys case-insensitively, so when v is a struct the keys are first adjusted to
+// what yaml.v2 expects.
+func (codec codecImplmentation) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(*map[string]interface{}); ok {
+		var raw map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+
+		converted, err := stringifyKeys(raw)
+		if err != nil {
+			return err
+		}
+
+		if *m == nil {
+			*m = make(map[string]interface{})
+		}
+		for k, val := range converted.(map[string]interface{}) {
+			(*m)[k] = val
+		}
+		return nil
+	}
+
+	var generic map[string]interface{}
+	if err := codec.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(canonicalizeKeys(generic, reflect.TypeOf(v)))
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, v)
+}
+
+// Returns true if the struct tag indicates that the field should not be inspected
+func (codec codecImplmentation) IsStructFieldIgnored(st reflect.StructTag) bool {
+	return st.Get("yaml") == "-"
+}
+
+// Returns empty string if the field has no alias
+func (codec codecImplmentation) GetStructFieldAlias(st reflect.StructTag) string {
+	if codec.IsStructFieldIgnored(st) {
+		return ""
+	}
+
+	if typeTag := st.Get("yaml"); typeTag != "" {
+		return strings.Split(typeTag, ",")[0]
+	}
+
+	return ""
+}
+
+func (codec codecImplmentation) FieldTypesConsistent(check, gold *reflection.StructField) (noDeeper bool, err error) {
+	// yaml.v2 produces map[interface{}]interface{} for nested maps (we convert these in
+	// Unmarshal, but they might still turn up). We can't look inside them.
+	if check.Type == "map[interface {}]interface {}" && (gold.Kind == "map" || gold.Kind == "struct") {
+		return true, nil
+	}
+
+	// yaml.v2 produces int for most integers, but int64 or uint64 for large ones. It
+	// also produces an integer for a float value that has no decimal point.
+	if isIntegerKind(check.Kind) && (isIntegerKind(gold.Kind) || strings.HasPrefix(gold.Kind, "float")) {
+		return true, nil
+	}
+
+	return false, errors.Errorf("field types inconsistent")
+}
+
+func isIntegerKind(kind string) bool {
+	return strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint")
+}
+
+// stringifyKeys recursively converts map[interface{}]interface{} values within v into
+// map[string]interface{}.
+func stringifyKeys(v interface{}) (interface{}, error) {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, val := range vv {
+			ks, ok := k.(string)
+			if !ok {
+				// Non-string keys (like `1: a`) are valid YAML, but we can't map them to
+				// struct fields. We'll use their string form.
+				ks = fmt.Sprint(k)
+			}
+
+			converted, err := stringifyKeys(val)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, exists := m[ks]; exists {
+				return nil, errors.Errorf("duplicate key after conversion to string: %v", ks)
+			}
+			m[ks] = converted
+		}
+		return m, nil
+
+	case []interface{}:
+		s := make([]interface{}, len(vv))
+		for i := range vv {
+			converted, err := stringifyKeys(vv[i])
+			if err != nil {
+				return nil, err
+			}
+			s[i] = converted
+		}
+		return s, nil
+	}
+
+	return v, nil
+}
+
+// canonicalizeKeys returns a copy of v with keys renamed to the exact form that yaml.v2
+// expects for the target type t, matching case-insensitively.
+func canonicalizeKeys(v interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			// Map the lowercased forms of the field name and alias to the key yaml.v2 wants
+			expectedKeys := make(map[string]string)
+			fieldTypes := make(map[string]reflect.Type)
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if field.PkgPath != "" || Codec.IsStructFieldIgnored(field.Tag) {
+					continue
+				}
+
+				yamlKey := Codec.GetStructFieldAlias(field.Tag)
+				if yamlKey == "" {
+					yamlKey = strings.ToLower(field.Name)
+				}
+
+				for _, name := range []string{field.Name, yamlKey} {
+					expectedKeys[strings.ToLower(name)] = yamlKey
+				}
+				fieldTypes[yamlKey] = field.Type
+			}
+
+			m := make(map[string]interface{}, len(vv))
+			for k, val := range vv {
+				yamlKey, ok := expectedKeys[strings.ToLower(k)]
+				if !ok {
+					// Not a field we know about; leave it for yaml.v2 to deal with
+					m[k] = val
+					continue
+				}
+				m[yamlKey] = canonicalizeKeys(val, fieldTypes[yamlKey])
+			}
+			return m
+
+		case reflect.Map:
+			m := make(map[string]interface{}, len(vv))
+			for k, val := range vv {
+				m[k] = canonicalizeKeys(val, t.Elem())
+			}
+			return m
+		}
+
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			s := make([]interface{}, len(vv))
+			for i := range vv {
+				s[i] = canonicalizeKeys(vv[i], t.Elem())
+			}
+			return s
+		}
+	}
+
+	return v
+}
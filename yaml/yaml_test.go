@@ -0,0 +1,143 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2019, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package yaml_test
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Psiphon-Inc/configloader-go"
+	"github.com/Psiphon-Inc/configloader-go/yaml"
+)
+
+func TestLoad(t *testing.T) {
+	type config struct {
+		Server struct {
+			ListenPort int    `yaml:"listen_port"`
+			Hostname   string // no alias, so yaml.v2 would expect "hostname"
+			Timeout    time.Duration
+		}
+		Limits struct {
+			MaxBytes uint64
+			Ratio    float32
+		}
+		Ignored string            `yaml:"-"`
+		Labels  map[string]string `conf:"optional"`
+		Started time.Time         `yaml:"started"`
+	}
+
+	readers := []io.Reader{
+		strings.NewReader(`
+server:
+  listen_port: 80
+  HostName: example.com
+  timeout: 5000000000
+limits:
+  maxbytes: 18446744073709551615
+  ratio: 1
+started: 2019-01-02T03:04:05Z
+`),
+		strings.NewReader(`
+Server:
+  listen_port: 8080
+labels:
+  a: aaa
+`),
+	}
+
+	var result config
+	md, err := configloader.Load(yaml.Codec, readers, []string{"config.yaml", "override.yaml"}, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	var want config
+	want.Server.ListenPort = 8080
+	want.Server.Hostname = "example.com"
+	want.Server.Timeout = 5 * time.Second
+	want.Limits.MaxBytes = 18446744073709551615
+	want.Limits.Ratio = 1
+	want.Labels = map[string]string{"a": "aaa"}
+	want.Started = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+
+	wantProvs := map[string]string{
+		"Server.listen_port": "override.yaml",
+		"Server.Hostname":    "config.yaml",
+		"Server.Timeout":     "config.yaml",
+		"Limits.MaxBytes":    "config.yaml",
+		"Limits.Ratio":       "config.yaml",
+		"labels.a":           "override.yaml", // map-within-struct keys keep the file's form
+		"started":            "config.yaml",
+	}
+	if len(md.Provenances) != len(wantProvs) {
+		t.Fatalf("provenances mismatch;\ngot  %v\nwant %v", md.Provenances, wantProvs)
+	}
+	for _, prov := range md.Provenances {
+		if wantProvs[prov.Key.String()] != prov.Src {
+			t.Fatalf("provenance mismatch for %v;\ngot  %v\nwant %v", prov.Key, md.Provenances, wantProvs)
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	type config struct {
+		A int
+		B string `yaml:"-" conf:"optional"`
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"vestigial field", "a: 1\nc: 2\n"},
+		{"ignored field", "a: 1\nb: x\n"},
+		{"type mismatch", "a: abc\n"},
+		{"missing required", "{}\n"},
+		{"bad yaml", "a: [1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result config
+			_, err := configloader.Load(yaml.Codec, []io.Reader{strings.NewReader(tt.doc)}, nil, nil, nil, &result)
+			if err == nil {
+				t.Fatalf("Load should have failed; result: %+v", result)
+			}
+		})
+	}
+}
+
+func TestLoad_Map(t *testing.T) {
+	readers := []io.Reader{strings.NewReader(`
+a:
+  b: 1
+  c: [x, {d: e}]
+1: one
+`)}
+
+	var result map[string]interface{}
+	_, err := configloader.Load(yaml.Codec, readers, nil, nil, nil, &result)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": 1,
+			"c": []interface{}{"x", map[string]interface{}{"d": "e"}},
+		},
+		"1": "one",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Fatalf("result mismatch;\ngot  %#v\nwant %#v", result, want)
+	}
+}